@@ -0,0 +1,518 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/poprih/ur-monitor/db"
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/ratelimit"
+	"github.com/poprih/ur-monitor/pkg/store"
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 200
+)
+
+// AdminUser is a user as returned by the admin API
+type AdminUser struct {
+	LineUserID    string     `json:"line_user_id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Plan          string     `json:"plan"`
+	PlanExpiresAt *time.Time `json:"plan_expires_at,omitempty"`
+	Subscriptions int        `json:"subscriptions"`
+}
+
+// AdminUserDetail is a user with their subscriptions and plan history
+type AdminUserDetail struct {
+	AdminUser
+	ActiveSubscriptions []AdminSubscription `json:"active_subscriptions"`
+	PlanHistory         []AdminPlanChange   `json:"plan_history"`
+}
+
+// AdminSubscription is a subscription as returned and accepted by the admin
+// API. Only RoomTypes, Mode and Filters are read from requests.
+type AdminSubscription struct {
+	ID        int                        `json:"id,omitempty"`
+	UnitID    int                        `json:"unit_id"`
+	UnitName  string                     `json:"unit_name,omitempty"`
+	RoomTypes []string                   `json:"room_types"`
+	Mode      models.SubscriptionMode    `json:"mode"`
+	Filters   models.SubscriptionFilters `json:"filters"`
+	CreatedAt time.Time                  `json:"created_at"`
+}
+
+// AdminPlanChange is a plan audit record as returned by the admin API
+type AdminPlanChange struct {
+	OldPlan   string     `json:"old_plan,omitempty"`
+	NewPlan   string     `json:"new_plan"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ChangedBy string     `json:"changed_by"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// AdminPlan is a plan as returned by the admin API. Limits are omitted when
+// unlimited.
+type AdminPlan struct {
+	Code                 string `json:"code"`
+	Name                 string `json:"name"`
+	MaxSubscriptions     *int   `json:"max_subscriptions,omitempty"`
+	MaxRoomTypes         *int   `json:"max_room_types,omitempty"`
+	CheckIntervalMinutes int    `json:"check_interval_minutes"`
+	AllowPersistent      bool   `json:"allow_persistent"`
+	IsDefault            bool   `json:"is_default"`
+}
+
+// AdminPlanAssignment is the body of a plan change request. A nil ExpiresAt
+// assigns the plan for good.
+type AdminPlanAssignment struct {
+	Plan      string     `json:"plan"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Reason    string     `json:"reason"`
+}
+
+// AdminUnit is a unit with its subscriber count as returned by the admin API
+type AdminUnit struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Code        models.UnitCode `json:"code"`
+	URL         string          `json:"url"`
+	Subscribers int             `json:"subscribers"`
+}
+
+// AdminHandler serves the admin JSON API under /api/admin/ using the
+// process-wide database pool. Requests must carry ADMIN_SECRET as a bearer
+// token.
+func AdminHandler(w http.ResponseWriter, r *http.Request) {
+	database, err := db.Pool(r.Context())
+	if err != nil {
+		log.Printf("Database connection failed: %v", err)
+		writeAdminError(w, http.StatusInternalServerError, "database connection failed")
+		return
+	}
+	NewAdminHandler(store.NewPostgres(database)).ServeHTTP(w, r)
+}
+
+// NewAdminHandler returns the admin API backed by stores
+//
+//	GET    /api/admin/users?q=&limit=&offset=
+//	GET    /api/admin/users/{id}
+//	GET    /api/admin/users/{id}/subscriptions
+//	PUT    /api/admin/users/{id}/subscriptions/{unit}
+//	DELETE /api/admin/users/{id}/subscriptions/{unit}
+//	PUT    /api/admin/users/{id}/plan
+//	GET    /api/admin/plans
+//	GET    /api/admin/units?q=&limit=&offset=
+//	POST   /api/admin/units/{id}/check
+func NewAdminHandler(stores store.Stores) http.Handler {
+	a := &admin{stores: stores}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/admin/users", a.listUsers)
+	mux.HandleFunc("GET /api/admin/users/{id}", a.getUser)
+	mux.HandleFunc("GET /api/admin/users/{id}/subscriptions", a.listSubscriptions)
+	mux.HandleFunc("PUT /api/admin/users/{id}/subscriptions/{unit}", a.saveSubscription)
+	mux.HandleFunc("DELETE /api/admin/users/{id}/subscriptions/{unit}", a.cancelSubscription)
+	mux.HandleFunc("PUT /api/admin/users/{id}/plan", a.assignPlan)
+	mux.HandleFunc("GET /api/admin/plans", a.listPlans)
+	mux.HandleFunc("GET /api/admin/units", a.listUnits)
+	mux.HandleFunc("POST /api/admin/units/{id}/check", a.checkUnit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			writeAdminError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminAuthorized reports whether the request carries the admin secret. The
+// API is disabled when ADMIN_SECRET is not set.
+func adminAuthorized(r *http.Request) bool {
+	secret := os.Getenv("ADMIN_SECRET")
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return secret != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+type admin struct {
+	stores store.Stores
+}
+
+func (a *admin) listUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := adminPage(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, err := a.stores.Users.Search(r.Context(), r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	result := make([]AdminUser, 0, len(users))
+	for _, user := range users {
+		summary, err := a.userSummary(r, user)
+		if err != nil {
+			a.internalError(w, err)
+			return
+		}
+		result = append(result, summary)
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+func (a *admin) getUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.user(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := a.userSummary(r, *user)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	detail := AdminUserDetail{AdminUser: summary, ActiveSubscriptions: []AdminSubscription{}, PlanHistory: []AdminPlanChange{}}
+
+	subs, err := a.stores.Subscriptions.ListByUser(r.Context(), user.LineUserID)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	for _, sub := range subs {
+		detail.ActiveSubscriptions = append(detail.ActiveSubscriptions, adminSubscription(sub))
+	}
+
+	changes, err := a.stores.Plans.History(r.Context(), user.LineUserID)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	for _, change := range changes {
+		detail.PlanHistory = append(detail.PlanHistory, AdminPlanChange{
+			OldPlan:   change.OldPlanCode,
+			NewPlan:   change.NewPlanCode,
+			ExpiresAt: change.ExpiresAt,
+			ChangedBy: change.ChangedBy,
+			Reason:    change.Reason,
+			CreatedAt: change.CreatedAt,
+		})
+	}
+	writeAdminJSON(w, http.StatusOK, detail)
+}
+
+func (a *admin) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.user(w, r)
+	if !ok {
+		return
+	}
+
+	subs, err := a.stores.Subscriptions.ListByUser(r.Context(), user.LineUserID)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	result := make([]AdminSubscription, 0, len(subs))
+	for _, sub := range subs {
+		result = append(result, adminSubscription(sub))
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+// saveSubscription creates or replaces a user's subscription to a unit.
+// Admins are not bound by the user's plan limits.
+func (a *admin) saveSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.user(w, r)
+	if !ok {
+		return
+	}
+	unit, ok := a.unit(w, r, "unit")
+	if !ok {
+		return
+	}
+
+	var body AdminSubscription
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	switch body.Mode {
+	case "":
+		body.Mode = models.SubscriptionModeOneShot
+	case models.SubscriptionModeOneShot, models.SubscriptionModePersistent:
+	default:
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid mode %q", body.Mode))
+		return
+	}
+	if err := models.ValidateRoomTypes(body.RoomTypes); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := body.Filters.Validate(); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.RoomTypes == nil {
+		body.RoomTypes = []string{}
+	}
+
+	err := a.stores.Subscriptions.Save(r.Context(), models.Subscription{
+		LineUserID: user.LineUserID,
+		UnitID:     unit.ID,
+		RoomTypes:  body.RoomTypes,
+		Mode:       body.Mode,
+		Filters:    body.Filters,
+	})
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	log.Printf("Admin saved subscription of %s to unit %d", user.LineUserID, unit.ID)
+
+	a.listSubscriptions(w, r)
+}
+
+func (a *admin) cancelSubscription(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.user(w, r)
+	if !ok {
+		return
+	}
+	unitID, err := strconv.Atoi(r.PathValue("unit"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid unit id")
+		return
+	}
+
+	cancelled, err := a.stores.Subscriptions.Cancel(r.Context(), user.LineUserID, unitID)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	if !cancelled {
+		writeAdminError(w, http.StatusNotFound, "subscription not found")
+		return
+	}
+	log.Printf("Admin cancelled subscription of %s to unit %d", user.LineUserID, unitID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) assignPlan(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var body AdminPlanAssignment
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if body.Plan == "" {
+		writeAdminError(w, http.StatusBadRequest, "plan is required")
+		return
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		writeAdminError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	if _, err := a.stores.Plans.Get(r.Context(), body.Plan); errors.Is(err, store.ErrNotFound) {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unknown plan %q", body.Plan))
+		return
+	} else if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	err := a.stores.Plans.Assign(r.Context(), id, body.Plan, body.ExpiresAt, "admin", body.Reason)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAdminError(w, http.StatusNotFound, "user not found")
+		return
+	} else if err != nil {
+		a.internalError(w, err)
+		return
+	}
+	log.Printf("Admin assigned plan %s to %s", body.Plan, id)
+
+	a.getUser(w, r)
+}
+
+func (a *admin) listPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := a.stores.Plans.List(r.Context())
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	result := make([]AdminPlan, 0, len(plans))
+	for _, plan := range plans {
+		result = append(result, AdminPlan{
+			Code:                 plan.Code,
+			Name:                 plan.Name,
+			MaxSubscriptions:     plan.MaxSubscriptions,
+			MaxRoomTypes:         plan.MaxRoomTypes,
+			CheckIntervalMinutes: int(plan.CheckInterval / time.Minute),
+			AllowPersistent:      plan.AllowPersistent,
+			IsDefault:            plan.IsDefault,
+		})
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+func (a *admin) listUnits(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := adminPage(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	units, err := a.stores.Units.Search(r.Context(), r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		a.internalError(w, err)
+		return
+	}
+
+	result := make([]AdminUnit, 0, len(units))
+	for _, unit := range units {
+		result = append(result, AdminUnit{
+			ID:          unit.ID,
+			Name:        unit.Name,
+			Code:        unit.Code,
+			URL:         unit.URL,
+			Subscribers: unit.Subscribers,
+		})
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+// checkUnit checks a single unit right away, whether or not it is due or
+// has subscribers. Like a scheduled check it records a snapshot and queues
+// alerts for new rooms, which the next dispatch sends.
+func (a *admin) checkUnit(w http.ResponseWriter, r *http.Request) {
+	unit, ok := a.unit(w, r, "id")
+	if !ok {
+		return
+	}
+
+	fetcher, err := urclient.NewHTTPClient(os.Getenv("UR_API_BASE_URL"), os.Getenv("UR_UNIT_ROOM_CHECK_PATH"), urclient.DefaultTimeout)
+	if err != nil {
+		a.internalError(w, fmt.Errorf("error creating UR client: %w", err))
+		return
+	}
+	limiter := ratelimit.NewTokenBucket(envFloat("UR_API_RATE", defaultURRequestRate), 1)
+
+	report := checkUnit(r.Context(), a.stores, fetcher, limiter, *unit)
+	log.Printf("Admin checked unit %d: %s", unit.ID, report.Status)
+
+	status := http.StatusOK
+	if report.Status != UnitChecked {
+		status = http.StatusBadGateway
+	}
+	writeAdminJSON(w, status, report)
+}
+
+// user looks up the user in the {id} path segment, writing the error
+// response if it can't
+func (a *admin) user(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := a.stores.Users.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		writeAdminError(w, http.StatusNotFound, "user not found")
+		return nil, false
+	} else if err != nil {
+		a.internalError(w, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// unit looks up the unit in the named path segment, writing the error
+// response if it can't
+func (a *admin) unit(w http.ResponseWriter, r *http.Request, name string) (*models.Unit, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid unit id")
+		return nil, false
+	}
+
+	unit, err := a.stores.Units.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAdminError(w, http.StatusNotFound, "unit not found")
+		return nil, false
+	} else if err != nil {
+		a.internalError(w, err)
+		return nil, false
+	}
+	return unit, true
+}
+
+// userSummary adds the user's active plan and subscription count
+func (a *admin) userSummary(r *http.Request, user models.User) (AdminUser, error) {
+	summary := AdminUser{LineUserID: user.LineUserID, CreatedAt: user.CreatedAt}
+
+	plan, err := a.stores.Plans.Active(r.Context(), user.LineUserID)
+	if err != nil {
+		return summary, err
+	}
+	summary.Plan = plan.Plan.Code
+	summary.PlanExpiresAt = plan.ExpiresAt
+
+	summary.Subscriptions, err = a.stores.Subscriptions.CountActive(r.Context(), user.LineUserID)
+	return summary, err
+}
+
+func (a *admin) internalError(w http.ResponseWriter, err error) {
+	log.Printf("Admin API error: %v", err)
+	writeAdminError(w, http.StatusInternalServerError, "internal error")
+}
+
+func adminSubscription(sub models.Subscription) AdminSubscription {
+	return AdminSubscription{
+		ID:        sub.ID,
+		UnitID:    sub.UnitID,
+		UnitName:  sub.UnitName,
+		RoomTypes: sub.RoomTypes,
+		Mode:      sub.Mode,
+		Filters:   sub.Filters,
+		CreatedAt: sub.CreatedAt,
+	}
+}
+
+// adminPage parses the limit and offset query parameters
+func adminPage(r *http.Request) (limit, offset int, err error) {
+	limit = defaultAdminPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxAdminPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxAdminPageSize)
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must not be negative")
+		}
+	}
+	return limit, offset, nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
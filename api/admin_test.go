@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/store"
+)
+
+const testAdminSecret = "test-admin-secret"
+
+// adminRequest sends a request to handler with the admin secret as bearer
+// token, or no Authorization header if secret is empty
+func adminRequest(t *testing.T, handler http.Handler, secret, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// adminDo sends an authorized request and decodes the JSON response into v,
+// failing unless it has the wanted status
+func adminDo(t *testing.T, handler http.Handler, method, path, body string, wantStatus int, v any) {
+	t.Helper()
+	w := adminRequest(t, handler, testAdminSecret, method, path, body)
+	if w.Code != wantStatus {
+		t.Fatalf("%s %s = %d %s, want %d", method, path, w.Code, w.Body, wantStatus)
+	}
+	if v != nil {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+}
+
+func TestAdminRequiresSecret(t *testing.T) {
+	handler := NewAdminHandler(store.NewMemory().Stores())
+
+	tests := []struct {
+		name   string
+		env    string
+		secret string
+	}{
+		{"no ADMIN_SECRET", "", testAdminSecret},
+		{"no ADMIN_SECRET or token", "", ""},
+		{"no token", testAdminSecret, ""},
+		{"wrong token", testAdminSecret, "wrong-secret"},
+		{"prefix of the secret", testAdminSecret, testAdminSecret[:4]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ADMIN_SECRET", tt.env)
+			for _, path := range []string{"/api/admin/users", "/api/admin/plans", "/api/admin/nowhere"} {
+				if w := adminRequest(t, handler, tt.secret, http.MethodGet, path, ""); w.Code != http.StatusUnauthorized {
+					t.Errorf("GET %s = %d, want %d", path, w.Code, http.StatusUnauthorized)
+				}
+			}
+		})
+	}
+
+	t.Run("basic auth", func(t *testing.T) {
+		t.Setenv("ADMIN_SECRET", testAdminSecret)
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/plans", nil)
+		req.SetBasicAuth("admin", testAdminSecret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET with basic auth = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestAdminRoutes(t *testing.T) {
+	t.Setenv("ADMIN_SECRET", testAdminSecret)
+	memory := store.NewMemory()
+	unit := memory.AddUnit(models.Unit{Name: "恵比寿ビュータワー"})
+	memory.SetUser(models.User{LineUserID: "U1"})
+	handler := NewAdminHandler(memory.Stores())
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{http.MethodGet, "/api/admin/plans", http.StatusOK, ""},
+		{http.MethodPost, "/api/admin/plans", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodDelete, "/api/admin/users", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPost, "/api/admin/users/U1/subscriptions/1", http.StatusMethodNotAllowed, "DELETE, PUT"},
+		{http.MethodGet, "/api/admin/users/U1/plan", http.StatusMethodNotAllowed, "PUT"},
+		{http.MethodGet, fmt.Sprintf("/api/admin/units/%d/check", unit.ID), http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/api/admin", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/admin/nowhere", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/admin/users/U1/unknown", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/admin/users/Unobody", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/admin/users/Unobody/subscriptions", http.StatusNotFound, ""},
+		{http.MethodPut, "/api/admin/users/U1/subscriptions/999", http.StatusNotFound, ""},
+		{http.MethodPut, "/api/admin/users/U1/subscriptions/abc", http.StatusBadRequest, ""},
+		{http.MethodPost, "/api/admin/units/999/check", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/admin/users?limit=0", http.StatusBadRequest, ""},
+		{http.MethodGet, "/api/admin/units?offset=-1", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := adminRequest(t, handler, testAdminSecret, tt.method, tt.path, "{}")
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", allow, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestAdminRoundTrip(t *testing.T) {
+	t.Setenv("ADMIN_SECRET", testAdminSecret)
+	memory := store.NewMemory()
+	unit := memory.AddUnit(models.Unit{Name: "恵比寿ビュータワー"})
+	memory.AddUnit(models.Unit{Name: "大島四丁目"})
+	stores := memory.Stores()
+	if _, err := stores.Users.Ensure(context.Background(), "U1"); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	handler := NewAdminHandler(stores)
+	subscriptionPath := fmt.Sprintf("/api/admin/users/U1/subscriptions/%d", unit.ID)
+
+	var units []AdminUnit
+	adminDo(t, handler, http.MethodGet, "/api/admin/units?q=恵比寿", "", http.StatusOK, &units)
+	if len(units) != 1 || units[0].ID != unit.ID || units[0].Subscribers != 0 {
+		t.Fatalf("units = %+v, want %s without subscribers", units, unit.Name)
+	}
+
+	var users []AdminUser
+	adminDo(t, handler, http.MethodGet, "/api/admin/users", "", http.StatusOK, &users)
+	if len(users) != 1 || users[0].LineUserID != "U1" || users[0].Plan != "free" || users[0].Subscriptions != 0 {
+		t.Fatalf("users = %+v, want U1 on the free plan without subscriptions", users)
+	}
+
+	// Invalid subscriptions are rejected without saving anything
+	for _, body := range []string{
+		`{"mode":"forever"}`,
+		`{"room_types":["3LDK","3LDK"]}`,
+		`{"filters":{"min_floor":5,"max_floor":2}}`,
+		`{`,
+	} {
+		adminDo(t, handler, http.MethodPut, subscriptionPath, body, http.StatusBadRequest, nil)
+	}
+
+	// Admins are not bound by the free plan's limits
+	var subs []AdminSubscription
+	body := `{"room_types":["2LDK","3LDK"],"mode":"persistent","filters":{"max_rent":150000}}`
+	adminDo(t, handler, http.MethodPut, subscriptionPath, body, http.StatusOK, &subs)
+	if len(subs) != 1 || subs[0].UnitID != unit.ID || subs[0].Mode != models.SubscriptionModePersistent ||
+		fmt.Sprint(subs[0].RoomTypes) != "[2LDK 3LDK]" || subs[0].Filters.MaxRent == nil || *subs[0].Filters.MaxRent != 150000 {
+		t.Fatalf("subscriptions after PUT = %+v", subs)
+	}
+
+	// Saving again replaces the subscription and defaults to one-shot
+	var replaced []AdminSubscription
+	adminDo(t, handler, http.MethodPut, subscriptionPath, `{}`, http.StatusOK, &replaced)
+	if len(replaced) != 1 || replaced[0].ID != subs[0].ID || replaced[0].Mode != models.SubscriptionModeOneShot ||
+		len(replaced[0].RoomTypes) != 0 || !replaced[0].Filters.IsEmpty() {
+		t.Fatalf("subscriptions after second PUT = %+v, want one one-shot subscription without conditions", replaced)
+	}
+
+	units = nil
+	adminDo(t, handler, http.MethodGet, "/api/admin/units?q=恵比寿", "", http.StatusOK, &units)
+	if len(units) != 1 || units[0].Subscribers != 1 {
+		t.Errorf("units = %+v, want 1 subscriber", units)
+	}
+
+	adminDo(t, handler, http.MethodPut, "/api/admin/users/U1/plan", `{"plan":"platinum"}`, http.StatusBadRequest, nil)
+	adminDo(t, handler, http.MethodPut, "/api/admin/users/Unobody/plan", `{"plan":"premium"}`, http.StatusNotFound, nil)
+	adminDo(t, handler, http.MethodPut, "/api/admin/users/U1/plan", `{"plan":"premium","expires_at":"2020-01-01T00:00:00Z"}`, http.StatusBadRequest, nil)
+
+	var user AdminUserDetail
+	adminDo(t, handler, http.MethodPut, "/api/admin/users/U1/plan", `{"plan":"premium","reason":"support"}`, http.StatusOK, &user)
+	if user.Plan != "premium" || user.Subscriptions != 1 || len(user.ActiveSubscriptions) != 1 || user.ActiveSubscriptions[0].UnitID != unit.ID {
+		t.Errorf("user after plan change = %+v, want premium with the subscription", user)
+	}
+	if len(user.PlanHistory) != 1 || user.PlanHistory[0].NewPlan != "premium" || user.PlanHistory[0].ChangedBy != "admin" || user.PlanHistory[0].Reason != "support" {
+		t.Errorf("plan history = %+v, want the change by admin", user.PlanHistory)
+	}
+
+	adminDo(t, handler, http.MethodDelete, subscriptionPath, "", http.StatusNoContent, nil)
+	adminDo(t, handler, http.MethodDelete, subscriptionPath, "", http.StatusNotFound, nil)
+
+	var remaining []AdminSubscription
+	adminDo(t, handler, http.MethodGet, "/api/admin/users/U1/subscriptions", "", http.StatusOK, &remaining)
+	if len(remaining) != 0 {
+		t.Errorf("subscriptions after DELETE = %+v, want none", remaining)
+	}
+	var after AdminUserDetail
+	adminDo(t, handler, http.MethodGet, "/api/admin/users/U1", "", http.StatusOK, &after)
+	if after.Plan != "premium" || after.Subscriptions != 0 || len(after.ActiveSubscriptions) != 0 {
+		t.Errorf("user after DELETE = %+v, want premium without subscriptions", after)
+	}
+}
@@ -1,140 +1,157 @@
 package api
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/poprih/ur-monitor/db"
 	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/command"
 	"github.com/poprih/ur-monitor/pkg/line"
+	"github.com/poprih/ur-monitor/pkg/store"
+	"github.com/poprih/ur-monitor/pkg/unitsearch"
 )
 
+const (
+	// maxUnitCandidates is how many properties are offered when a name is ambiguous
+	maxUnitCandidates = 5
+	// maxUnitMatches is how many similarly named units are ranked when no
+	// name matches exactly
+	maxUnitMatches = 20
+)
+
+// resolveUnit finds the unit the user meant, tolerating typos and
+// half-width/full-width differences. If the name is ambiguous it replies with
+// the closest candidates as quick replies, each sending retry(candidate name).
+func resolveUnit(ctx context.Context, units store.UnitStore, lineClient *line.LineClient, name string, replyToken string, retry func(string) string) (int, string, error) {
+	all, err := units.Match(ctx, name, maxUnitMatches)
+	if err != nil {
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.DatabaseError)
+		return 0, "", err
+	}
+
+	candidates := make([]unitsearch.Candidate, 0, len(all))
+	for _, unit := range all {
+		candidates = append(candidates, unitsearch.Candidate{ID: unit.ID, Name: unit.Name})
+	}
+
+	match, suggestions := unitsearch.Resolve(name, candidates, maxUnitCandidates)
+	if match != nil {
+		return match.ID, match.Name, nil
+	}
+
+	if len(suggestions) == 0 {
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.InvalidUnitName)
+		return 0, "", fmt.Errorf("unit not found: %s", name)
+	}
+
+	actions := make([]line.Action, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		actions = append(actions, line.MessageAction{Label: suggestion.Name, Text: retry(suggestion.Name)})
+	}
+	lineClient.SendReplyMessages(ctx, replyToken, line.TextMessage{
+		Text:       line.MessageTemplates.AmbiguousUnitName,
+		QuickReply: line.NewQuickReply(actions...),
+	})
+	return 0, "", fmt.Errorf("ambiguous unit name: %s (%d candidates)", name, len(suggestions))
+}
+
 // handleUnsubscribe handles the unsubscribe command
-func handleUnsubscribe(db *sql.DB, lineClient *line.LineClient, userID, messageText string, replyToken string) error {
-	// Extract mansion name from the message (remove the "-" prefix)
-	mansionName := strings.TrimSpace(messageText[1:])
-	
+func handleUnsubscribe(ctx context.Context, stores store.Stores, lineClient *line.LineClient, userID string, cmd command.Unsubscribe, replyToken string) error {
 	// Check if the mansion exists
-	var unitID int
-	err := db.QueryRow("SELECT id FROM units WHERE unit_name ILIKE $1", mansionName).Scan(&unitID)
+	unitID, mansionName, err := resolveUnit(ctx, stores.Units, lineClient, cmd.UnitName, replyToken, func(name string) string {
+		return command.Unsubscribe{UnitName: name}.String()
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
-			lineClient.SendReplyMessage(replyToken, line.MessageTemplates.InvalidUnitName)
-			return fmt.Errorf("unit not found: %s", mansionName)
-		}
-		lineClient.SendReplyMessage(replyToken, line.MessageTemplates.InvalidUnitName)
 		return err
 	}
-	
+
 	// Cancel subscription (soft delete)
-	_, err = db.Exec(`
-		UPDATE subscriptions 
-		SET deleted_at = NOW() 
-		WHERE line_user_id = $1 AND unit_id = $2 AND deleted_at IS NULL`, 
-		userID, unitID)
-	if err != nil {
-		lineClient.SendReplyMessage(replyToken, line.FormatBilingualMessage(line.MessageTemplates.UnsubscribeError, mansionName))
+	if _, err := stores.Subscriptions.Cancel(ctx, userID, unitID); err != nil {
+		lineClient.SendReplyMessage(ctx, replyToken, line.FormatBilingualMessage(line.MessageTemplates.UnsubscribeError, mansionName))
 		return err
 	}
-	
-	// Check if there are other subscribers for this mansion
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE unit_id = $1 AND deleted_at IS NULL", unitID).Scan(&count)
-	if err == nil && count == 0 {
-		// If no other subscribers, update mansion subscription status
-		_, err = db.Exec("UPDATE units SET is_subscribed = FALSE WHERE id = $1", unitID)
-		if err != nil {
-			log.Println("Error updating unit subscription status:", err)
-		}
-	}
-	
+
 	// Send unsubscribe success message
-	lineClient.SendReplyMessage(replyToken, line.FormatBilingualMessage(line.MessageTemplates.UnsubscribeSuccess, mansionName))
+	lineClient.SendReplyMessage(ctx, replyToken, line.FormatBilingualMessage(line.MessageTemplates.UnsubscribeSuccess, mansionName))
 	return nil
 }
 
-// handleSubscribe handles the subscribe command
-func handleSubscribe(db *sql.DB, lineClient *line.LineClient, userID string, parts []string, replyToken string) error {
-	var unitName string
-	var roomTypes []string
-	
-	if len(parts) == 1 {
-		unitName = parts[0]
-	} else if len(parts) == 2 {
-		unitName = strings.TrimSpace(parts[0])
-		roomTypes = strings.Split(strings.TrimSpace(parts[1]), "&")
-	} else {
-		lineClient.SendReplyMessage(replyToken, line.MessageTemplates.InvalidFormat)
-		return fmt.Errorf("invalid message format")
-	}
-
-	// Check if user is premium and subscription count
-	var isPremium bool
-	var subscriptionCount int
-	err := db.QueryRow("SELECT is_premium FROM users WHERE line_user_id = $1", userID).Scan(&isPremium)
+// handleUnsubscribeAll cancels every active subscription of the user
+func handleUnsubscribeAll(ctx context.Context, stores store.Stores, lineClient *line.LineClient, userID string, replyToken string) error {
+	count, err := stores.Subscriptions.CancelAll(ctx, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// User doesn't exist yet, create them with default values
-			_, insertErr := db.Exec("INSERT INTO users (line_user_id, is_premium) VALUES ($1, FALSE) ON CONFLICT (line_user_id) DO NOTHING", userID)
-			if insertErr != nil {
-				return insertErr
-			}
-			isPremium = false // Default to non-premium
-		} else {
-			return err
-		}
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.DatabaseError)
+		return err
+	}
+	if count == 0 {
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.NoSubscriptions)
+		return nil
 	}
 
-	if !isPremium {
-		err = db.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE line_user_id = $1 AND deleted_at IS NULL", userID).Scan(&subscriptionCount)
-		if err != nil {
-			// COUNT(*) should never return no rows, but handle it gracefully
-			if err == sql.ErrNoRows {
-				subscriptionCount = 0
-			} else {
-				return err
-			}
-		}
+	lineClient.SendReplyMessage(ctx, replyToken, line.FormatBilingualMessage(line.MessageTemplates.UnsubscribeAllSuccess, count))
+	return nil
+}
 
-		if subscriptionCount >= 1 {
-			lineClient.SendReplyMessage(replyToken, line.MessageTemplates.SubscriptionLimitReached)
-			return fmt.Errorf("subscription limit reached")
-		}
+// planLimitReply returns the message explaining which limit of the user's
+// plan err refers to, and false if err is not a plan limit
+func planLimitReply(err error) (string, bool) {
+	var limitErr *models.PlanLimitError
+	if !errors.As(err, &limitErr) {
+		return "", false
+	}
+
+	switch {
+	case errors.Is(err, models.ErrSubscriptionLimit):
+		return line.FormatBilingualMessage(line.MessageTemplates.SubscriptionLimitReached, limitErr.Limit), true
+	case errors.Is(err, models.ErrRoomTypeLimit):
+		return line.FormatBilingualMessage(line.MessageTemplates.RoomTypeLimitReached, limitErr.Limit), true
+	default:
+		return line.MessageTemplates.PersistentNotAllowed, true
 	}
+}
+
+// handleSubscribe handles the subscribe command
+func handleSubscribe(ctx context.Context, stores store.Stores, lineClient *line.LineClient, userID string, cmd command.Subscribe, replyToken string) error {
+	unitName := cmd.UnitName
+	roomTypes := cmd.RoomTypes
+	filters := cmd.Filters
+	mode := cmd.Mode
 
 	// Check if unit exists
-	var unitID int
-	err = db.QueryRow("SELECT id FROM units WHERE unit_name ILIKE $1", unitName).Scan(&unitID)
+	unitID, unitName, err := resolveUnit(ctx, stores.Units, lineClient, unitName, replyToken, func(name string) string {
+		retry := cmd
+		retry.UnitName = name
+		return retry.String()
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
-			lineClient.SendReplyMessage(replyToken, line.MessageTemplates.InvalidUnitName)
-			return fmt.Errorf("unit not found: %s", unitName)
-		}
-		lineClient.SendReplyMessage(replyToken, line.MessageTemplates.InvalidUnitName)
 		return err
 	}
 
-	// Convert room types to JSON array
-	roomTypesJSON, err := json.Marshal(roomTypes)
-	if err != nil {
+	// Insert subscription. The user is created if needed, and the limits of
+	// their plan are checked in the same transaction so that parallel
+	// messages cannot both pass them.
+	err = stores.Subscriptions.Subscribe(ctx, models.Subscription{
+		LineUserID: userID,
+		UnitID:     unitID,
+		RoomTypes:  roomTypes,
+		Mode:       mode,
+		Filters:    filters,
+	})
+	if reply, ok := planLimitReply(err); ok {
+		lineClient.SendReplyMessage(ctx, replyToken, reply)
 		return err
 	}
-
-	// Insert subscription
-	_, err = db.Exec(`
-		INSERT INTO subscriptions (line_user_id, unit_id, room_types, deleted_at) 
-		VALUES ($1::text, $2, $3, NULL) 
-		ON CONFLICT (line_user_id, unit_id) 
-		DO UPDATE SET room_types = $3, deleted_at = NULL`, 
-		userID, unitID, roomTypesJSON)
 	if err != nil {
-		lineClient.SendReplyMessage(replyToken, line.FormatBilingualMessage(line.MessageTemplates.SubscriptionError, unitName))
+		lineClient.SendReplyMessage(ctx, replyToken, line.FormatBilingualMessage(line.MessageTemplates.SubscriptionError, unitName))
 		return err
 	}
 
@@ -147,50 +164,155 @@ func handleSubscribe(db *sql.DB, lineClient *line.LineClient, userID string, par
 	} else {
 		confirmationMsg = line.FormatBilingualMessage(line.MessageTemplates.SubscriptionSuccess, unitName)
 	}
+	if !filters.IsEmpty() {
+		confirmationMsg += "\n" + line.FormatBilingualMessage(line.MessageTemplates.SpecifiedFilters, filters.String())
+	}
+	if mode == models.SubscriptionModePersistent {
+		confirmationMsg += "\n" + line.MessageTemplates.PersistentMode
+	}
 
-	// Get all active subscriptions for this user
-	rows, err := db.Query(`
-		SELECT u.unit_name, s.room_types
-		FROM subscriptions s
-		JOIN units u ON s.unit_id = u.id
-		WHERE s.line_user_id = $1 AND s.deleted_at IS NULL
-	`, userID)
+	// Show all active subscriptions for this user
+	subscriptions, err := currentSubscriptions(ctx, stores.Subscriptions, userID)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-	
-	var subscriptions []string
-	for rows.Next() {
-		var subUnitName string
-		var subRoomTypesJSON []byte
-		if err := rows.Scan(&subUnitName, &subRoomTypesJSON); err != nil {
+	if len(subscriptions) > 0 {
+		confirmationMsg += "\n\n" + line.MessageTemplates.CurrentSubscriptions + "\n" + strings.Join(subscriptions, "\n")
+	}
+
+	lineClient.SendReplyMessage(ctx, replyToken, confirmationMsg)
+	return nil
+}
+
+// currentSubscriptions returns one line per active subscription of the user
+func currentSubscriptions(ctx context.Context, subscriptions store.SubscriptionStore, userID string) ([]string, error) {
+	subs, err := subscriptions.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		entry := sub.UnitName
+		if len(sub.RoomTypes) > 0 {
+			entry = fmt.Sprintf("%s: %s", sub.UnitName, strings.Join(sub.RoomTypes, "、"))
+		}
+		if !sub.Filters.IsEmpty() {
+			entry += fmt.Sprintf(" [%s]", sub.Filters.String())
+		}
+		if sub.Mode == models.SubscriptionModePersistent {
+			entry += " (継続 / keep)"
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// handleList replies with the user's current subscriptions
+func handleList(ctx context.Context, stores store.Stores, lineClient *line.LineClient, userID string, replyToken string) error {
+	subscriptions, err := currentSubscriptions(ctx, stores.Subscriptions, userID)
+	if err != nil {
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.DatabaseError)
+		return err
+	}
+	if len(subscriptions) == 0 {
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.NoSubscriptions)
+		return nil
+	}
+
+	lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.CurrentSubscriptions+"\n"+strings.Join(subscriptions, "\n"))
+	return nil
+}
+
+// jst is the time zone used when showing check times to users
+var jst = time.FixedZone("JST", 9*60*60)
+
+// handleStatus replies with the last check time and result of each subscription
+func handleStatus(ctx context.Context, stores store.Stores, lineClient *line.LineClient, userID string, replyToken string) error {
+	subs, err := stores.Subscriptions.ListByUser(ctx, userID)
+	if err != nil {
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.DatabaseError)
+		return err
+	}
+
+	var lines []string
+	for _, sub := range subs {
+		check, err := stores.Units.LastCheck(ctx, sub.UnitID)
+		if err != nil {
+			log.Println("Error loading last check:", err)
 			continue
 		}
 
-		var subRoomTypes []string
-		if len(subRoomTypesJSON) > 0 {
-			if err := json.Unmarshal(subRoomTypesJSON, &subRoomTypes); err != nil {
-				continue
-			}
+		if check == nil {
+			lines = append(lines, fmt.Sprintf("%s: 未確認 / Not checked yet", sub.UnitName))
+			continue
 		}
+		lines = append(lines, fmt.Sprintf("%s: 空室 %d 件 / %d available (%s)",
+			sub.UnitName, check.RoomCount, check.RoomCount, check.CheckedAt.In(jst).Format("2006-01-02 15:04 MST")))
+	}
 
-		if len(subRoomTypes) > 0 {
-			subscriptions = append(subscriptions, fmt.Sprintf("%s: %s", subUnitName, strings.Join(subRoomTypes, "、")))
+	if len(lines) == 0 {
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.NoSubscriptions)
+		return nil
+	}
+
+	lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.SubscriptionStatus+"\n"+strings.Join(lines, "\n"))
+	return nil
+}
+
+// handleMessage parses a text message and runs the resulting command
+func handleMessage(ctx context.Context, stores store.Stores, lineClient *line.LineClient, userID, messageText string, replyToken string) error {
+	cmd, err := command.Parse(messageText)
+	if err != nil {
+		// Messages with options were most likely meant as a subscription
+		if strings.ContainsAny(messageText, ":：") {
+			lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.InvalidFormat)
 		} else {
-			subscriptions = append(subscriptions, subUnitName)
+			lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.UnknownCommand)
 		}
+		return err
 	}
 
-	if len(subscriptions) > 0 {
-		confirmationMsg += "\n\n" + line.MessageTemplates.CurrentSubscriptions + "\n" + strings.Join(subscriptions, "\n")
+	switch cmd := cmd.(type) {
+	case command.Help:
+		return lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.Help)
+	case command.List:
+		return handleList(ctx, stores, lineClient, userID, replyToken)
+	case command.Status:
+		return handleStatus(ctx, stores, lineClient, userID, replyToken)
+	case command.UnsubscribeAll:
+		return handleUnsubscribeAll(ctx, stores, lineClient, userID, replyToken)
+	case command.Unsubscribe:
+		return handleUnsubscribe(ctx, stores, lineClient, userID, cmd, replyToken)
+	case command.Subscribe:
+		return handleSubscribe(ctx, stores, lineClient, userID, cmd, replyToken)
+	default:
+		lineClient.SendReplyMessage(ctx, replyToken, line.MessageTemplates.UnknownCommand)
+		return fmt.Errorf("unhandled command: %T", cmd)
 	}
-	
-	lineClient.SendReplyMessage(replyToken, confirmationMsg)
-	return nil
 }
 
+// HandleLine is the webhook endpoint for the LINE channel. It uses the
+// process-wide database pool.
 func HandleLine(w http.ResponseWriter, r *http.Request) {
+	database, err := db.Pool(r.Context())
+	if err != nil {
+		log.Printf("Database connection failed: %v", err)
+		http.Error(w, "Database connection failed", http.StatusInternalServerError)
+		return
+	}
+	NewLineHandler(store.NewPostgres(database))(w, r)
+}
+
+// NewLineHandler returns the LINE webhook handler backed by stores
+func NewLineHandler(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleLine(w, r, stores)
+	}
+}
+
+func handleLine(w http.ResponseWriter, r *http.Request, stores store.Stores) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
@@ -209,6 +331,18 @@ func HandleLine(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Empty request body", http.StatusBadRequest)
 		return
 	}
+
+	// Verify the request was signed by LINE before acting on it
+	channelSecret := os.Getenv("LINE_CHANNEL_SECRET")
+	if channelSecret == "" {
+		http.Error(w, "LINE_CHANNEL_SECRET is not set", http.StatusInternalServerError)
+		return
+	}
+	if !line.ValidateSignature(channelSecret, body, r.Header.Get(line.SignatureHeader)) {
+		log.Printf("Rejected webhook with invalid signature from %s", r.RemoteAddr)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
 	log.Printf("Received webhook: %s", body)
 	var event models.LineWebhookEvent
 
@@ -218,114 +352,49 @@ func HandleLine(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	database, err := db.ConnectDB()
-	if err != nil {
-		http.Error(w, "Database connection failed", http.StatusInternalServerError)
-		return
-	}
-	defer database.Close()
-
 	channelToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
 	if channelToken == "" {
 		http.Error(w, "LINE_CHANNEL_ACCESS_TOKEN is not set", http.StatusInternalServerError)
 		return
 	}
 	
-	lineClient := line.NewLineClient(channelToken)
+	lineClient := line.NewLineClient(channelToken, line.WithBaseURL(os.Getenv("LINE_API_BASE_URL")))
+	ctx := r.Context()
 
 	for _, e := range event.Events {
 		switch e.Type {
 		case "follow":
 			// Store both the user ID and their reply token
-			_, err = database.Exec("INSERT INTO users (line_user_id, reply_token) VALUES ($1, $2) ON CONFLICT (line_user_id) DO UPDATE SET reply_token = $2", 
-				e.Source.UserID, e.ReplyToken)
+			err = stores.Users.Follow(ctx, e.Source.UserID, e.ReplyToken)
 			if err != nil {
 				log.Println("Error inserting/updating user:", err)
 				http.Error(w, "Failed to save user", http.StatusInternalServerError)
 				return
 			}
 			fmt.Fprint(w, "User saved successfully")
-			lineClient.SendReplyMessage(e.ReplyToken, line.MessageTemplates.WelcomeMessage)
+			lineClient.SendReplyMessage(ctx, e.ReplyToken, line.MessageTemplates.WelcomeMessage)
 
 		case "message":
 			// Update the reply token for the user with each message
-			_, err = database.Exec("UPDATE users SET reply_token = $1 WHERE line_user_id = $2", 
-				e.ReplyToken, e.Source.UserID)
+			err = stores.Users.SetReplyToken(ctx, e.Source.UserID, e.ReplyToken)
 			if err != nil {
 				log.Println("Error updating reply token:", err)
 			}					
 			var userID = e.Source.UserID
 			
-			messageText := strings.TrimSpace(e.Message.Text)
-			
-			// Handle unsubscribe command
-			if strings.HasPrefix(messageText, "-") {
-				if err := handleUnsubscribe(database, lineClient, userID, messageText, e.ReplyToken); err != nil {
-					log.Println("Error handling unsubscribe:", err)
-				}
-				return
-			}
-			
-			// Handle subscribe command
-			parts := strings.Split(messageText, ":")
-			if err := handleSubscribe(database, lineClient, userID, parts, e.ReplyToken); err != nil {
-				log.Println("Error handling subscribe:", err)
+			if err := handleMessage(ctx, stores, lineClient, userID, e.Message.Text, e.ReplyToken); err != nil {
+				log.Println("Error handling message:", err)
 			}
 
 		case "unfollow":
-			// Check if the user has any subscriptions
-			rows, err := database.Query("SELECT unit_id FROM subscriptions WHERE line_user_id = $1", e.Source.UserID)
-			if err != nil {
-				log.Println("Error querying user subscriptions:", err)
-			} else {
-				defer rows.Close()
-				
-				// Process each unit the user is subscribed to
-				for rows.Next() {
-					var unitID int
-					if err := rows.Scan(&unitID); err != nil {
-						log.Println("Error scanning unit ID:", err)
-						continue
-					}
-					
-					// Check if this unit has other subscribers
-					var count int
-					err = database.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE unit_id = $1 AND line_user_id != $2", 
-						unitID, e.Source.UserID).Scan(&count)
-					if err != nil {
-						// COUNT(*) should never return no rows, but handle it gracefully
-						if err == sql.ErrNoRows {
-							count = 0
-						} else {
-							log.Println("Error counting other subscribers:", err)
-							continue
-						}
-					}
-					
-					// If no other subscribers, set the unit subscription status to false
-					if count == 0 {
-						_, err = database.Exec("UPDATE units SET is_subscribed = FALSE WHERE id = $1", unitID)
-						if err != nil {
-							log.Println("Error updating unit subscription status:", err)
-						} else {
-							log.Printf("Updated unit ID %d as it has no more subscribers", unitID)
-						}
-					}
-				}
-				
-					// Delete all subscriptions for this user 
-				_, err = database.Exec("DELETE FROM subscriptions WHERE line_user_id = $1", e.Source.UserID)
-				if err != nil {
-					log.Println("Error deleting user subscriptions:", err)
-				}
+			// Delete all subscriptions for this user, then the user
+			if err := stores.Subscriptions.DeleteAll(ctx, e.Source.UserID); err != nil {
+				log.Println("Error deleting user subscriptions:", err)
 			}
-				// Delete the user from the users table
-			_, err = database.Exec("DELETE FROM users WHERE line_user_id = $1", e.Source.UserID)
-		
-			if err != nil {
+			if err := stores.Users.Delete(ctx, e.Source.UserID); err != nil {
 				log.Println("Error deleting user:", err)
-			}			
-			
+			}
+
 		default:
 			http.Error(w, "Invalid event type", http.StatusBadRequest)
 		}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/line"
+	"github.com/poprih/ur-monitor/pkg/store"
+)
+
+const testChannelSecret = "test-channel-secret"
+
+// reply is a reply message sent to the fake LINE API
+type reply struct {
+	Token string
+	Texts []string
+}
+
+// fakeLINE is a LINE Messaging API that records the replies it receives
+type fakeLINE struct {
+	mu      sync.Mutex
+	replies []reply
+}
+
+// newFakeLINE starts a fake LINE API and points the webhook handler at it
+func newFakeLINE(t *testing.T) *fakeLINE {
+	t.Helper()
+	f := &fakeLINE{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/bot/message/reply" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct {
+			ReplyToken string `json:"replyToken"`
+			Messages   []struct {
+				Text string `json:"text"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sent := reply{Token: body.ReplyToken}
+		for _, message := range body.Messages {
+			sent.Texts = append(sent.Texts, message.Text)
+		}
+		f.mu.Lock()
+		f.replies = append(f.replies, sent)
+		f.mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("LINE_CHANNEL_SECRET", testChannelSecret)
+	t.Setenv("LINE_CHANNEL_ACCESS_TOKEN", "test-token")
+	t.Setenv("LINE_API_BASE_URL", server.URL)
+	return f
+}
+
+// lastReply returns the text of the latest reply sent with token
+func (f *fakeLINE) lastReply(t *testing.T, token string) string {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.replies) - 1; i >= 0; i-- {
+		if f.replies[i].Token == token {
+			return strings.Join(f.replies[i].Texts, "\n")
+		}
+	}
+	t.Fatalf("no reply sent with token %q", token)
+	return ""
+}
+
+// sign returns the X-Line-Signature of body
+func sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(testChannelSecret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBody builds a webhook body with a single event from userID
+func webhookBody(eventType, userID, replyToken, text string) []byte {
+	event := fmt.Sprintf(`{"type":%q,"timestamp":1700000000000,"source":{"type":"user","userId":%q},"replyToken":%q`, eventType, userID, replyToken)
+	if eventType == "message" {
+		event += fmt.Sprintf(`,"message":{"type":"text","id":"1","text":%q}`, text)
+	}
+	return []byte(`{"destination":"Ubot","events":[` + event + `}]}`)
+}
+
+// postWebhook sends a signed webhook body to handler
+func postWebhook(t *testing.T, handler http.Handler, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/line", strings.NewReader(string(body)))
+	req.Header.Set(line.SignatureHeader, sign(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLineFollowSavesUserAndWelcomes(t *testing.T) {
+	lineAPI := newFakeLINE(t)
+	memory := store.NewMemory()
+	handler := NewLineHandler(memory.Stores())
+
+	rec := postWebhook(t, handler, webhookBody("follow", "U1", "token-1", ""))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	user, err := memory.Stores().Users.Get(context.Background(), "U1")
+	if err != nil {
+		t.Fatalf("Users.Get() error = %v", err)
+	}
+	if user.ReplyToken != "token-1" {
+		t.Errorf("ReplyToken = %q, want %q", user.ReplyToken, "token-1")
+	}
+	if got := lineAPI.lastReply(t, "token-1"); got != line.MessageTemplates.WelcomeMessage {
+		t.Errorf("reply = %q, want the welcome message", got)
+	}
+}
+
+func TestLineSubscribe(t *testing.T) {
+	lineAPI := newFakeLINE(t)
+	memory := store.NewMemory()
+	unit := memory.AddUnit(models.Unit{Name: "恵比寿ビュータワー", Code: mustParseUnitCode(t, "20_1230")})
+	handler := NewLineHandler(memory.Stores())
+
+	postWebhook(t, handler, webhookBody("follow", "U1", "token-1", ""))
+	rec := postWebhook(t, handler, webhookBody("message", "U1", "token-2", "恵比寿ビュータワー:3LDK&4LDK:rent<=15万"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	subs, err := memory.Stores().Subscriptions.ListByUser(context.Background(), "U1")
+	if err != nil {
+		t.Fatalf("ListByUser() error = %v", err)
+	}
+	if len(subs) != 1 {
+		t.Fatalf("subscriptions = %v, want one", subs)
+	}
+	sub := subs[0]
+	if sub.UnitID != unit.ID || strings.Join(sub.RoomTypes, "&") != "3LDK&4LDK" || sub.Mode != models.SubscriptionModeOneShot {
+		t.Errorf("subscription = %+v, want unit %d, 3LDK&4LDK, oneshot", sub, unit.ID)
+	}
+	if sub.Filters.MaxRent == nil || *sub.Filters.MaxRent != 150000 {
+		t.Errorf("MaxRent = %v, want 150000", sub.Filters.MaxRent)
+	}
+
+	want := line.FormatBilingualMessage(line.MessageTemplates.SubscriptionSuccess, unit.Name)
+	if got := lineAPI.lastReply(t, "token-2"); !strings.HasPrefix(got, want) {
+		t.Errorf("reply = %q, want it to start with %q", got, want)
+	}
+}
+
+func TestLineSubscribeOverQuota(t *testing.T) {
+	lineAPI := newFakeLINE(t)
+	memory := store.NewMemory()
+	memory.AddUnit(models.Unit{Name: "恵比寿ビュータワー", Code: mustParseUnitCode(t, "20_1230")})
+	memory.AddUnit(models.Unit{Name: "大島四丁目", Code: mustParseUnitCode(t, "20_4560")})
+	handler := NewLineHandler(memory.Stores())
+
+	// The free plan allows a single subscription
+	postWebhook(t, handler, webhookBody("message", "U1", "token-1", "恵比寿ビュータワー"))
+	rec := postWebhook(t, handler, webhookBody("message", "U1", "token-2", "大島四丁目"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	want := line.FormatBilingualMessage(line.MessageTemplates.SubscriptionLimitReached, 1)
+	if got := lineAPI.lastReply(t, "token-2"); got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+
+	count, err := memory.Stores().Subscriptions.CountActive(context.Background(), "U1")
+	if err != nil {
+		t.Fatalf("CountActive() error = %v", err)
+	}
+	if count != 1 {
+		t.Errorf("active subscriptions = %d, want 1", count)
+	}
+}
+
+func TestLineRejectsUnsignedWebhooks(t *testing.T) {
+	newFakeLINE(t)
+	body := webhookBody("follow", "U1", "token-1", "")
+	tampered := webhookBody("follow", "U2", "token-1", "")
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+	}{
+		{"tampered body", tampered, sign(body)},
+		{"missing signature", body, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := store.NewMemory()
+			handler := NewLineHandler(memory.Stores())
+
+			req := httptest.NewRequest(http.MethodPost, "/api/line", strings.NewReader(string(tt.body)))
+			if tt.signature != "" {
+				req.Header.Set(line.SignatureHeader, tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", rec.Code)
+			}
+			for _, userID := range []string{"U1", "U2"} {
+				if _, err := memory.Stores().Users.Get(context.Background(), userID); !errors.Is(err, store.ErrNotFound) {
+					t.Errorf("Users.Get(%s) error = %v, want ErrNotFound", userID, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/poprih/ur-monitor/db"
+	"github.com/poprih/ur-monitor/pkg/line"
+	"github.com/poprih/ur-monitor/pkg/notify"
+	"github.com/poprih/ur-monitor/pkg/store"
+)
+
+// DispatchNotificationsHandler is an HTTP handler that sends queued
+// notifications. It uses the process-wide database pool.
+func DispatchNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	database, err := db.Pool(r.Context())
+	if err != nil {
+		log.Printf("Database connection failed: %v", err)
+		http.Error(w, "Database connection failed", http.StatusInternalServerError)
+		return
+	}
+	NewDispatchNotificationsHandler(database)(w, r)
+}
+
+// NewDispatchNotificationsHandler returns the notification dispatch handler
+// backed by database
+func NewDispatchNotificationsHandler(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dispatchNotifications(w, r, database)
+	}
+}
+
+func dispatchNotifications(w http.ResponseWriter, r *http.Request, database *sql.DB) {
+	if !cronAuthorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// Only allow scheduled requests (from GitHub Actions)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := DispatchNotifications(r.Context(), database)
+	if err != nil {
+		log.Printf("Error dispatching notifications: %v", err)
+		http.Error(w, fmt.Sprintf("Error dispatching notifications: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// cronAuthorized reports whether the request carries CHECK_ROOMS_SECRET,
+// which the scheduled workflows send. Nothing is authorized while it is not
+// set.
+func cronAuthorized(r *http.Request) bool {
+	secret := os.Getenv("CHECK_ROOMS_SECRET")
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return secret != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// DispatchNotifications sends a batch of queued notifications over LINE
+func DispatchNotifications(ctx context.Context, database *sql.DB) (notify.Result, error) {
+	channelToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
+	if channelToken == "" {
+		return notify.Result{}, errors.New("LINE_CHANNEL_ACCESS_TOKEN is not set")
+	}
+
+	dispatcher := &notify.Dispatcher{
+		Queue:  store.NewPostgres(database).Notifications,
+		Client: line.NewLineClient(channelToken, line.WithBaseURL(os.Getenv("LINE_API_BASE_URL"))),
+	}
+
+	result, err := dispatcher.Run(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	log.Printf("Dispatched notifications: %d sent, %d retried, %d failed", result.Sent, result.Retried, result.Failed)
+	return result, nil
+}
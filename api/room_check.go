@@ -1,29 +1,140 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/poprih/ur-monitor/db"
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/checkcursor"
 	"github.com/poprih/ur-monitor/pkg/line"
+	"github.com/poprih/ur-monitor/pkg/notify"
+	"github.com/poprih/ur-monitor/pkg/ratelimit"
+	"github.com/poprih/ur-monitor/pkg/snapshot"
+	"github.com/poprih/ur-monitor/pkg/store"
+	"github.com/poprih/ur-monitor/pkg/urclient"
 )
 
-// URResponse represents the response from UR API
-type URResponse struct {
-	Count int      `json:"count"`
-	Room  []string `json:"room"`
+const (
+	// defaultCheckConcurrency is how many units are checked at once
+	defaultCheckConcurrency = 4
+	// defaultURRequestRate is the sustained number of UR API requests per second
+	defaultURRequestRate = 2.0
+	// defaultCheckBudget bounds a whole run so it finishes inside the
+	// serverless function's maxDuration. Self-hosted deployments can raise
+	// it with CHECK_ROOMS_BUDGET.
+	defaultCheckBudget = 50 * time.Second
+	// deadlineMargin is left over before the caller's own deadline to write
+	// the response
+	deadlineMargin = 5 * time.Second
+)
+
+// UnitStatus is the outcome of checking a single unit
+type UnitStatus string
+
+const (
+	UnitChecked UnitStatus = "checked"
+	UnitSkipped UnitStatus = "skipped"
+	UnitFailed  UnitStatus = "failed"
+)
+
+// UnitReport describes what happened to one unit during a room check
+type UnitReport struct {
+	ID     int             `json:"id"`
+	Name   string          `json:"name"`
+	Code   models.UnitCode `json:"code"`
+	Status UnitStatus      `json:"status"`
+	// Vacancies is the number of rooms currently available, and
+	// NewVacancies those that appeared since the previous check
+	Vacancies    int `json:"vacancies"`
+	NewVacancies int `json:"new_vacancies"`
+	// NotificationsFailed counts the alerts that could not be queued; the
+	// unit then fails as a whole and nothing is queued for it
+	NotificationsQueued int    `json:"notifications_queued"`
+	NotificationsFailed int    `json:"notifications_failed"`
+	Error               string `json:"error,omitempty"`
+}
+
+// CheckReport is the result of a room check run returned to the scheduler
+type CheckReport struct {
+	Shard               string    `json:"shard"`
+	StartedAt           time.Time `json:"started_at"`
+	DurationMS          int64     `json:"duration_ms"`
+	Checked             int       `json:"checked"`
+	Skipped             int       `json:"skipped"`
+	Failed              int       `json:"failed"`
+	NewVacancies        int       `json:"new_vacancies"`
+	NotificationsQueued int       `json:"notifications_queued"`
+	NotificationsFailed int       `json:"notifications_failed"`
+	// Cursor is the id of the last unit the shard has finished; the next
+	// run resumes after it. It is 0 once the cycle is complete.
+	Cursor        int  `json:"cursor"`
+	CycleComplete bool `json:"cycle_complete"`
+	// ShardSkipped is set when another run was still checking the shard,
+	// in which case nothing was checked
+	ShardSkipped bool         `json:"shard_skipped"`
+	Units        []UnitReport `json:"units"`
+	// Error is set when the run could not be carried out at all
+	Error string `json:"error,omitempty"`
+}
+
+// String renders the report totals for logs
+func (r *CheckReport) String() string {
+	if r.ShardSkipped {
+		return fmt.Sprintf("shard=%s skipped, another run is still checking it", r.Shard)
+	}
+	return fmt.Sprintf("shard=%s checked=%d skipped=%d failed=%d new_vacancies=%d queued=%d cursor=%d cycle_complete=%t",
+		r.Shard, r.Checked, r.Skipped, r.Failed, r.NewVacancies, r.NotificationsQueued, r.Cursor, r.CycleComplete)
+}
+
+// StatusCode maps the outcome of a run to an HTTP status: 200 when every
+// unit was checked, 207 when some were skipped or failed or the whole shard
+// was skipped, and 500 when the run failed outright or no unit could be
+// checked.
+func (r *CheckReport) StatusCode() int {
+	switch {
+	case r.Error != "":
+		return http.StatusInternalServerError
+	case r.Checked == 0 && r.Failed > 0:
+		return http.StatusInternalServerError
+	case r.ShardSkipped || r.Skipped > 0 || r.Failed > 0 || r.NotificationsFailed > 0:
+		return http.StatusMultiStatus
+	default:
+		return http.StatusOK
+	}
 }
 
-// CheckRoomsHandler is an HTTP handler that checks for available units
+// CheckRoomsHandler is an HTTP handler that checks for available units and
+// responds with a JSON CheckReport. It uses the process-wide database pool.
 func CheckRoomsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("Authorization") != "Bearer " + os.Getenv("CHECK_ROOMS_SECRET") {
+	database, err := db.Pool(r.Context())
+	if err != nil {
+		log.Printf("Database connection failed: %v", err)
+		http.Error(w, "Database connection failed", http.StatusInternalServerError)
+		return
+	}
+	NewCheckRoomsHandler(database)(w, r)
+}
+
+// NewCheckRoomsHandler returns the room check handler backed by database
+func NewCheckRoomsHandler(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checkRooms(w, r, database)
+	}
+}
+
+func checkRooms(w http.ResponseWriter, r *http.Request, database *sql.DB) {
+	if !cronAuthorized(r) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -33,175 +144,307 @@ func CheckRoomsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := checkAndNotifyAvailableRooms()
+	// Large deployments split the units over several scheduled calls,
+	// e.g. ?shard=2&of=6
+	shard, err := checkcursor.ParseShard(r.URL.Query().Get("shard"), r.URL.Query().Get("of"))
 	if err != nil {
-		log.Printf("Error checking rooms: %v", err)
-		http.Error(w, fmt.Sprintf("Error checking rooms: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	fmt.Fprint(w, "Room check completed successfully")
+	report := CheckRooms(r.Context(), database, shard)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(report.StatusCode())
+	json.NewEncoder(w).Encode(report)
+}
+
+// CheckRooms runs a room check over a shard of the subscribed units and
+// returns its report. Failures are recorded in the report rather than
+// returned, so callers can always log or serve it.
+func CheckRooms(ctx context.Context, database *sql.DB, shard checkcursor.Shard) *CheckReport {
+	fetcher, err := urclient.NewHTTPClient(os.Getenv("UR_API_BASE_URL"), os.Getenv("UR_UNIT_ROOM_CHECK_PATH"), urclient.DefaultTimeout)
+	if err != nil {
+		log.Printf("Error creating UR client: %v", err)
+		return &CheckReport{
+			Shard:     shard.String(),
+			StartedAt: time.Now(),
+			Units:     []UnitReport{},
+			Error:     fmt.Sprintf("Error creating UR client: %v", err),
+		}
+	}
+	return runRoomCheck(ctx, store.NewPostgres(database), fetcher, shard)
+}
+
+// runRoomCheck runs a room check over a shard with the given stores and UR
+// client and returns its report
+func runRoomCheck(ctx context.Context, stores store.Stores, fetcher urclient.RoomAvailabilityFetcher, shard checkcursor.Shard) *CheckReport {
+	report := &CheckReport{Shard: shard.String(), StartedAt: time.Now(), Units: []UnitReport{}}
+
+	if err := checkAndNotifyAvailableRooms(ctx, stores, fetcher, shard, report); err != nil {
+		log.Printf("Error checking rooms: %v", err)
+		report.Error = fmt.Sprintf("Error checking rooms: %v", err)
+	}
+	report.DurationMS = time.Since(report.StartedAt).Milliseconds()
+
+	log.Printf("Room check completed: %s", report)
+	return report
+}
+
+// indexedUnit is a unit handed to a worker along with its position in the run
+type indexedUnit struct {
+	index int
+	unit  models.Unit
+}
+
+// indexedResult is the report for the unit at index
+type indexedResult struct {
+	index  int
+	report UnitReport
 }
 
-// checkAndNotifyAvailableRooms fetches all active subscriptions and checks for available rooms
-func checkAndNotifyAvailableRooms() error {
-	// Connect to the database
-	database, err := db.ConnectDB()
+// checkAndNotifyAvailableRooms checks the units with active subscriptions in
+// a shard for available rooms using a bounded pool of workers, filling in
+// report as it goes. Requests to the UR API share a rate limiter. The run
+// holds the shard's lock throughout and is skipped if another run has it.
+// It resumes after the shard's cursor and units not checked before the
+// deadline are reported as skipped and left for the next run.
+func checkAndNotifyAvailableRooms(ctx context.Context, stores store.Stores, fetcher urclient.RoomAvailabilityFetcher, shard checkcursor.Shard, report *CheckReport) error {
+	release, err := stores.Cursors.Lock(ctx, shard)
+	if errors.Is(err, checkcursor.ErrLocked) {
+		log.Printf("Shard %s is still being checked by another run, skipping", shard)
+		report.ShardSkipped = true
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("database connection failed: %w", err)
-	}
-	defer database.Close()
-
-	// Get all units that have active subscriptions
-	rows, err := database.Query(`
-		SELECT DISTINCT u.unit_name, u.unit_code 
-		FROM units u
-		JOIN subscriptions s ON u.id = s.unit_id
-		JOIN users usr ON s.line_user_id = usr.line_user_id
-		WHERE s.deleted_at IS NULL
-	`)
+		return err
+	}
+	defer release()
+
+	cursor, err := stores.Cursors.Load(ctx, shard)
 	if err != nil {
-		return fmt.Errorf("failed to query subscribed units: %w", err)
+		return err
 	}
-	defer rows.Close()
 
-	// Process each unit
-	for rows.Next() {
-		var unitName, unitCode string
-		if err := rows.Scan(&unitName, &unitCode); err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue
-		}
+	units, err := stores.Units.ListSubscribed(ctx, shard.Index, shard.Count, cursor)
+	if err != nil {
+		return err
+	}
 
-		// Parse unit_code to get required parameters
-		parts := strings.Split(unitCode, "_")
-		if len(parts) != 2 {
-			log.Printf("Invalid unit_code format: %s", unitCode)
-			continue
-		}
+	ctx, cancel := context.WithDeadline(ctx, checkDeadline(ctx))
+	defer cancel()
 
-		shisya := parts[0]
-		danchi := parts[1][:3]
-		shikibetu := parts[1][3:]
+	limiter := ratelimit.NewTokenBucket(envFloat("UR_API_RATE", defaultURRequestRate), 1)
+	workers := min(envInt("CHECK_ROOMS_CONCURRENCY", defaultCheckConcurrency), len(units))
 
-		// Check if this unit has available rooms
-		response, err := checkAvailableRooms(shisya, danchi, shikibetu)
-		if err != nil {
-			log.Printf("Error fetching data for unit %s: %v", unitName, err)
-			continue
-		}
+	jobs := make(chan indexedUnit)
+	results := make(chan indexedResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- indexedResult{job.index, checkUnit(ctx, stores, fetcher, limiter, job.unit)}
+			}
+		}()
+	}
 
-		// If rooms are available, notify subscribed users
-		if response.Count > 0 {
-			err = notifySubscribedUsers(database, unitName, response)
-			if err != nil {
-				log.Printf("Error notifying users for unit %s: %v", unitName, err)
+	// Feed units until they run out or the deadline passes; whatever is
+	// left over is skipped
+	go func() {
+		defer close(jobs)
+		for i, unit := range units {
+			select {
+			case jobs <- indexedUnit{i, unit}:
+			case <-ctx.Done():
+				return
 			}
-		} else {
-			log.Printf("No available rooms for unit %s", unitName)
 		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
-		// Add a small delay to avoid overwhelming the UR API
-		time.Sleep(1 * time.Second)
+	// Units never handed to a worker keep the skipped status
+	reports := make([]UnitReport, len(units))
+	for i, unit := range units {
+		reports[i] = UnitReport{ID: unit.ID, Name: unit.Name, Code: unit.Code, Status: UnitSkipped}
+	}
+	for r := range results {
+		reports[r.index] = r.report
 	}
 
-	return nil
+	for _, unit := range reports {
+		switch unit.Status {
+		case UnitChecked:
+			report.Checked++
+		case UnitSkipped:
+			report.Skipped++
+		case UnitFailed:
+			report.Failed++
+		}
+		report.NewVacancies += unit.NewVacancies
+		report.NotificationsQueued += unit.NotificationsQueued
+		report.NotificationsFailed += unit.NotificationsFailed
+	}
+	report.Units = append(report.Units, reports...)
+
+	if ctx.Err() != nil && report.Skipped > 0 {
+		log.Printf("Room check deadline reached, skipped %d of %d units", report.Skipped, len(units))
+	}
+
+	// Workers finish out of order, so the cursor only moves past the units
+	// before the first one that was skipped. Units that failed are not
+	// retried until the next cycle.
+	report.Cursor = cursor
+	done := 0
+	for done < len(reports) && reports[done].Status != UnitSkipped {
+		report.Cursor = reports[done].ID
+		done++
+	}
+
+	// Save progress even if the request itself has been cancelled
+	saveCtx := context.WithoutCancel(ctx)
+	if done == len(reports) {
+		report.Cursor = 0
+		report.CycleComplete = true
+		return stores.Cursors.Complete(saveCtx, shard)
+	}
+	return stores.Cursors.Advance(saveCtx, shard, report.Cursor)
 }
 
-// checkAvailableRooms fetches available room data from the UR API
-func checkAvailableRooms(shisya, danchi, shikibetu string) (*URResponse, error) {
-	baseURL := os.Getenv("UR_API_BASE_URL")
-	apiPath := os.Getenv("UR_UNIT_ROOM_CHECK_PATH")
-	if baseURL == "" {
-		return nil, fmt.Errorf("UR_API_BASE_URL is not set")
+// checkUnit fetches the rooms of a single unit, records a snapshot and
+// queues alerts for rooms that appeared since the last check
+func checkUnit(ctx context.Context, stores store.Stores, fetcher urclient.RoomAvailabilityFetcher, limiter *ratelimit.TokenBucket, unit models.Unit) UnitReport {
+	report := UnitReport{ID: unit.ID, Name: unit.Name, Code: unit.Code}
+	fail := func(err error) UnitReport {
+		report.Status = UnitFailed
+		report.Error = err.Error()
+		return report
 	}
 
-	url := fmt.Sprintf("%s%s", baseURL, apiPath)
-	postData := fmt.Sprintf("shisya=%s&danchi=%s&shikibetu=%s", shisya, danchi, shikibetu)
+	if err := unit.Code.Validate(); err != nil {
+		log.Printf("Unit %s: %v", unit.Name, err)
+		return fail(err)
+	}
+
+	// Wait for our turn at the UR API
+	if err := limiter.Wait(ctx); err != nil {
+		report.Status = UnitSkipped
+		return report
+	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(postData)))
+	// Check if this unit has available rooms
+	response, err := fetcher.FetchRooms(ctx, unit.Code)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			report.Status = UnitSkipped
+			return report
+		}
+		log.Printf("Error fetching data for unit %s: %v", unit.Name, err)
+		return fail(err)
 	}
+	report.Vacancies = response.Count
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-	req.Header.Set("Accept", "application/json, text/javascript, */*; q=0.01")
-	req.Header.Set("Origin", "https://www.ur-net.go.jp")
-	req.Header.Set("Referer", "https://www.ur-net.go.jp/")
+	subs, err := stores.Subscriptions.ListByUnit(ctx, unit.ID)
+	if err != nil {
+		log.Printf("Error querying subscribed users for unit %s: %v", unit.Name, err)
+		return fail(fmt.Errorf("failed to query subscribed users: %w", err))
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Compare with the last seen rooms and queue alerts for the rooms that
+	// appeared. Both are saved together or not at all, so if anything
+	// fails the same rooms are reported on the next run.
+	var alerts []notify.Alert
+	changes, err := stores.Rooms.Record(ctx, unit.ID, response.Room, func(changes snapshot.Changes) []notify.Alert {
+		alerts = vacancyAlerts(unit, response, subs, changes.Appeared)
+		return alerts
+	})
 	if err != nil {
-		return nil, err
+		log.Printf("Error recording rooms for unit %s: %v", unit.Name, err)
+		report.NotificationsFailed = len(alerts)
+		return fail(err)
 	}
-	defer resp.Body.Close()
+	report.NewVacancies = len(changes.Appeared)
+	report.NotificationsQueued = len(alerts)
+
+	if len(changes.Appeared) > 0 {
+		log.Printf("%d new rooms for unit %s, queued %d alerts", len(changes.Appeared), unit.Name, len(alerts))
+	} else if response.Count > 0 {
+		log.Printf("No new rooms for unit %s (%d still available)", unit.Name, response.Count)
+	} else {
+		log.Printf("No available rooms for unit %s", unit.Name)
+	}
+
+	report.Status = UnitChecked
+	return report
+}
 
-	var data URResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+// checkDeadline returns when a run has to stop starting new work: the
+// budget from now, pulled in if the caller's deadline is sooner
+func checkDeadline(ctx context.Context) time.Time {
+	budget := defaultCheckBudget
+	if d, err := time.ParseDuration(os.Getenv("CHECK_ROOMS_BUDGET")); err == nil && d > 0 {
+		budget = d
 	}
 
-	return &data, nil
+	deadline := time.Now().Add(budget)
+	if parent, ok := ctx.Deadline(); ok {
+		if parent = parent.Add(-deadlineMargin); parent.Before(deadline) {
+			deadline = parent
+		}
+	}
+	return deadline
 }
 
-// notifySubscribedUsers notifies all users subscribed to a particular unit
-func notifySubscribedUsers(db *sql.DB, unitName string, response *URResponse) error {
-	// Find all users subscribed to this unit
-	rows, err := db.Query(`
-		SELECT usr.line_user_id, usr.reply_token, s.room_types
-		FROM users usr
-		JOIN subscriptions s ON usr.line_user_id = s.line_user_id
-		JOIN units u ON s.unit_id = u.id
-		WHERE u.unit_name = $1 AND s.deleted_at IS NULL
-	`, unitName)
-	if err != nil {
-		return fmt.Errorf("failed to query subscribed users: %w", err)
+// envInt reads a positive integer from the environment, falling back to def
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
 	}
-	defer rows.Close()
+	return def
+}
 
-	channelToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
-	if channelToken == "" {
-		return fmt.Errorf("LINE_CHANNEL_ACCESS_TOKEN is not set")
+// envFloat reads a positive number from the environment, falling back to def
+func envFloat(key string, def float64) float64 {
+	if f, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && f > 0 {
+		return f
 	}
-	
-	lineClient := line.NewLineClient(channelToken)
+	return def
+}
 
-	// Send notification to each subscribed user
-	for rows.Next() {
-		var userID, replyToken string
-		var subscribedRoomTypesJSON []byte
-		if err := rows.Scan(&userID, &replyToken, &subscribedRoomTypesJSON); err != nil {
-			log.Printf("Error scanning user row: %v", err)
-			continue
-		}
+// vacancyAlerts returns an alert for every subscription to unit whose
+// conditions match a room that appeared since the last check. The alerts are
+// queued with the snapshot and delivered by the notification dispatcher.
+func vacancyAlerts(unit models.Unit, response *urclient.Response, subs []models.Subscription, appeared []urclient.Room) []notify.Alert {
+	if len(appeared) == 0 {
+		return nil
+	}
 
-		// Parse subscribed room types
-		var subscribedRoomTypes []string
-		if len(subscribedRoomTypesJSON) > 0 {
-			if err := json.Unmarshal(subscribedRoomTypesJSON, &subscribedRoomTypes); err != nil {
-				log.Printf("Error parsing room types JSON: %v", err)
-				continue
-			}
-		}
+	appearedKeys := make(map[string]bool, len(appeared))
+	for _, room := range appeared {
+		appearedKeys[room.Key()] = true
+	}
 
-		// Check if any of the user's subscribed room types match available rooms
+	propertyURL := absoluteURURL(unit.URL)
+	imageURL := absoluteURURL(unit.Image)
+
+	// Everyone with the same mode receives an identical alert, which lets
+	// the dispatcher multicast it
+	messages := make(map[models.SubscriptionMode]line.Message)
+	var alerts []notify.Alert
+	for _, sub := range subs {
+		// Check if any newly appeared room matches the user's room types and filters
 		shouldNotify := false
-		if len(subscribedRoomTypes) == 0 {
-			// If no room types specified, notify for any availability
-			shouldNotify = true
-		} else {
-			// Check if any of the subscribed room types are available
-			for _, subscribedRoomType := range subscribedRoomTypes {
-				for _, availableRoom := range response.Room {
-					if availableRoom == subscribedRoomType {
-						shouldNotify = true
-						break
-					}
-				}
-				if shouldNotify {
-					break
-				}
+		for _, availableRoom := range appeared {
+			if matchesRoomTypes(availableRoom, sub.RoomTypes) &&
+				sub.Filters.Allows(availableRoom.Rent, availableRoom.FloorArea, availableRoom.Floor) {
+				shouldNotify = true
+				break
 			}
 		}
 
@@ -209,67 +452,174 @@ func notifySubscribedUsers(db *sql.DB, unitName string, response *URResponse) er
 			continue
 		}
 
-		// Prepare notification message
-		var messageBuilder strings.Builder
-		messageBuilder.WriteString(fmt.Sprintf("🔔 *UR %s - 空室通知 / Vacancy Notification*\n\n", unitName))
-		messageBuilder.WriteString(fmt.Sprintf("空室数 / Available rooms: %d\n", response.Count))
-		messageBuilder.WriteString("\n空室タイプ / Available room types:\n")
-		for _, roomType := range response.Room {
-			messageBuilder.WriteString(fmt.Sprintf("- %s\n", roomType))
+		message, ok := messages[sub.Mode]
+		if !ok {
+			message = vacancyFlexMessage(unit.Name, propertyURL, imageURL, response, appearedKeys, sub.Mode)
+			messages[sub.Mode] = message
 		}
 
-		// Get the property URL from the database
-		var propertyURL string
-		err = db.QueryRow("SELECT url FROM units WHERE unit_name = $1", unitName).Scan(&propertyURL)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				log.Printf("No URL found for unit: %s", unitName)
-			} else {
-				log.Printf("Error getting property URL: %v", err)
-			}
-		} else {
-			fullURL := fmt.Sprintf("https://www.ur-net.go.jp%s", propertyURL)
-			messageBuilder.WriteString(fmt.Sprintf("\n物件詳細 / Property details: %s\n", fullURL))
+		// One-shot subscriptions are ended by the dispatcher once the alert is delivered
+		alerts = append(alerts, notify.Alert{UserID: sub.LineUserID, SubscriptionID: sub.ID, Messages: []line.Message{message}})
+	}
+
+	return alerts
+}
+
+// matchesRoomTypes reports whether room is one of roomTypes. An empty list
+// matches any room.
+func matchesRoomTypes(room urclient.Room, roomTypes []string) bool {
+	if len(roomTypes) == 0 {
+		return true
+	}
+	for _, roomType := range roomTypes {
+		if room.Type == roomType {
+			return true
 		}
+	}
+	return false
+}
 
-		messageBuilder.WriteString("\n⚠️ ご注意 / Important:\n")
-		messageBuilder.WriteString("- 空室は先着順です。お早めにご応募ください。\n")
-		messageBuilder.WriteString("- この物件の通知は自動的に解除されます。\n\n")
-		messageBuilder.WriteString("- Vacancies are filled on a first-come, first-served basis. Please apply as soon as possible.\n")
-		messageBuilder.WriteString("- This property notification will be automatically unsubscribed.\n")
+// urBaseURL is prepended to the relative paths stored in units.url and units.image
+const urBaseURL = "https://www.ur-net.go.jp"
 
-		message := messageBuilder.String()
+// maxFlexRooms caps the rooms listed in a vacancy card so that it stays
+// within LINE's Flex message size limit
+const maxFlexRooms = 10
 
-		// Send push notification using LINE API
-		err = lineClient.SendPushMessage(userID, message)
-		if err != nil {
-			log.Printf("Error sending push message to user %s: %v", userID, err)
-			continue
+// absoluteURURL turns a path on the UR site into an absolute URL. Empty
+// paths stay empty and absolute URLs are returned unchanged.
+func absoluteURURL(path string) string {
+	if path == "" || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return urBaseURL + path
+}
+
+// vacancyFlexMessage renders a vacancy alert as a Flex card with the unit
+// image, every vacant room with its rent and size, and a link to the property
+func vacancyFlexMessage(unitName, propertyURL, imageURL string, response *urclient.Response, appearedKeys map[string]bool, mode models.SubscriptionMode) line.FlexMessage {
+	body := []line.FlexComponent{
+		line.TextComponent{Text: fmt.Sprintf("🔔 UR %s", unitName), Size: "lg", Weight: "bold", Wrap: true},
+		line.TextComponent{Text: "空室通知 / Vacancy Notification", Size: "sm", Color: "#888888"},
+		line.TextComponent{Text: fmt.Sprintf("空室数 / Available rooms: %d", response.Count), Size: "sm", Margin: "md"},
+		line.SeparatorComponent{Margin: "md"},
+	}
+
+	for i, room := range response.Room {
+		if i == maxFlexRooms {
+			body = append(body, line.TextComponent{
+				Text:   fmt.Sprintf("他 %d 件 / and %d more", len(response.Room)-maxFlexRooms, len(response.Room)-maxFlexRooms),
+				Size:   "xs",
+				Color:  "#888888",
+				Margin: "md",
+			})
+			break
 		}
 
-		// After successful notification, unsubscribe the user from this unit
-		if err := unsubscribeUser(db, userID, unitName); err != nil {
-			log.Printf("Error unsubscribing user %s from unit %s: %v", userID, unitName, err)
-			continue
+		marker := "・"
+		if appearedKeys[room.Key()] {
+			marker = "🆕 "
+		}
+		roomBox := line.BoxComponent{
+			Layout: "vertical",
+			Margin: "md",
+			Contents: []line.FlexComponent{
+				line.TextComponent{Text: marker + roomTitle(room), Size: "sm", Weight: "bold", Wrap: true},
+			},
+		}
+		if details := roomDetails(room); details != "" {
+			roomBox.Contents = append(roomBox.Contents, line.TextComponent{Text: details, Size: "xs", Color: "#555555", Wrap: true})
+		}
+		body = append(body, roomBox)
+	}
+
+	body = append(body,
+		line.SeparatorComponent{Margin: "md"},
+		line.TextComponent{Text: vacancyNotes(mode), Size: "xxs", Color: "#888888", Wrap: true, Margin: "md"},
+	)
+
+	bubble := line.BubbleContainer{
+		Body: &line.BoxComponent{Layout: "vertical", Contents: body},
+	}
+	if imageURL != "" {
+		bubble.Hero = &line.ImageComponent{URL: imageURL, Size: "full", AspectRatio: "20:13", AspectMode: "cover"}
+		if propertyURL != "" {
+			bubble.Hero.Action = line.URIAction{Label: "View on UR", URI: propertyURL}
+		}
+	}
+	if propertyURL != "" {
+		bubble.Footer = &line.BoxComponent{
+			Layout: "vertical",
+			Contents: []line.FlexComponent{
+				line.ButtonComponent{Action: line.URIAction{Label: "UR で見る / View on UR", URI: propertyURL}, Style: "primary"},
+			},
 		}
 	}
 
-	return nil
+	return line.FlexMessage{
+		AltText:  fmt.Sprintf("UR %s - 新着空室 %d 件 / %d new vacancies", unitName, len(appearedKeys), len(appearedKeys)),
+		Contents: bubble,
+	}
 }
 
-// unsubscribeUser removes the subscription for a specific user and unit
-func unsubscribeUser(db *sql.DB, userID string, unitName string) error {
-	_, err := db.Exec(`
-		UPDATE subscriptions s
-		SET deleted_at = NOW()
-		FROM units u
-		WHERE s.unit_id = u.id
-		AND u.unit_name = $1
-		AND s.line_user_id = $2
-	`, unitName, userID)
-	
-	if err != nil {
-		return fmt.Errorf("failed to unsubscribe user: %w", err)
+// vacancyNotes returns the bilingual notes shown at the bottom of an alert
+func vacancyNotes(mode models.SubscriptionMode) string {
+	notes := []string{
+		"⚠️ ご注意 / Important:",
+		"- 空室は先着順です。お早めにご応募ください。",
+	}
+	if mode == models.SubscriptionModePersistent {
+		notes = append(notes, "- この物件の通知は継続されます。解除するには「-物件名」を送信してください。")
+	} else {
+		notes = append(notes, "- この物件の通知は自動的に解除されます。")
+	}
+	notes = append(notes, "- Vacancies are filled on a first-come, first-served basis. Please apply as soon as possible.")
+	if mode == models.SubscriptionModePersistent {
+		notes = append(notes, "- You will keep receiving notifications for this property. Send \"-Property Name\" to unsubscribe.")
+	} else {
+		notes = append(notes, "- This property notification will be automatically unsubscribed.")
+	}
+	return strings.Join(notes, "\n")
+}
+
+// roomTitle describes a room by type, number and floor, e.g. "3LDK 1号棟305号室 3階"
+func roomTitle(room urclient.Room) string {
+	details := []string{room.Type}
+	if room.RoomNumber != "" {
+		details = append(details, room.RoomNumber)
+	}
+	if room.Floor > 0 {
+		details = append(details, fmt.Sprintf("%d階", room.Floor))
+	}
+	return strings.Join(details, " ")
+}
+
+// roomDetails describes a room's size and price, e.g.
+// "65.2㎡ / 家賃 Rent 120,500円 + 共益費 Fee 4,300円"
+func roomDetails(room urclient.Room) string {
+	var parts []string
+	if room.FloorArea > 0 {
+		parts = append(parts, fmt.Sprintf("%s㎡", strconv.FormatFloat(room.FloorArea, 'f', -1, 64)))
+	}
+	if room.Rent > 0 {
+		rent := fmt.Sprintf("家賃 Rent %s", formatYen(room.Rent))
+		if room.CommonFee > 0 {
+			rent += fmt.Sprintf(" + 共益費 Fee %s", formatYen(room.CommonFee))
+		}
+		parts = append(parts, rent)
+	}
+	return strings.Join(parts, " / ")
+}
+
+// formatYen formats an amount of yen with thousands separators, e.g. "85,400円"
+func formatYen(amount int) string {
+	digits := strconv.Itoa(amount)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
 	}
-	return nil
+	return b.String() + "円"
 }
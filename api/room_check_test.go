@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/checkcursor"
+	"github.com/poprih/ur-monitor/pkg/store"
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+// roomCheckFixture is a memory store with three subscribed units and a fake
+// UR API
+type roomCheckFixture struct {
+	memory  *store.Memory
+	fetcher *urclient.FakeFetcher
+	ok      models.Unit
+	down    models.Unit
+	broken  models.Unit
+	sub     models.Subscription
+}
+
+func newRoomCheckFixture(t *testing.T) *roomCheckFixture {
+	t.Helper()
+	t.Setenv("UR_API_RATE", "1000")
+	ctx := context.Background()
+
+	f := &roomCheckFixture{memory: store.NewMemory(), fetcher: urclient.NewFakeFetcher()}
+	f.ok = f.memory.AddUnit(models.Unit{Name: "恵比寿ビュータワー", Code: mustParseUnitCode(t, "20_1230")})
+	f.down = f.memory.AddUnit(models.Unit{Name: "大島四丁目", Code: mustParseUnitCode(t, "20_4560")})
+	f.broken = f.memory.AddUnit(models.Unit{Name: "光が丘パークタウン", Code: mustParseUnitCode(t, "20_7890")})
+
+	stores := f.memory.Stores()
+	for i, unit := range []models.Unit{f.ok, f.down, f.broken} {
+		userID := []string{"U1", "U2", "U3"}[i]
+		if _, err := stores.Users.Ensure(ctx, userID); err != nil {
+			t.Fatalf("Ensure() error = %v", err)
+		}
+		if err := stores.Subscriptions.Save(ctx, models.Subscription{LineUserID: userID, UnitID: unit.ID, RoomTypes: []string{"3LDK"}}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	subs, err := stores.Subscriptions.ListByUnit(ctx, f.ok.ID)
+	if err != nil || len(subs) != 1 {
+		t.Fatalf("ListByUnit() = %v, %v", subs, err)
+	}
+	f.sub = subs[0]
+
+	f.fetcher.SetError(f.down.Code, &urclient.StatusError{StatusCode: http.StatusServiceUnavailable, Body: "maintenance"})
+	f.fetcher.SetError(f.broken.Code, &urclient.DecodeError{ContentType: "text/html", Body: "<html>", Err: errors.New("invalid character '<'")})
+	return f
+}
+
+// run checks every unit, marking them as due first so that consecutive runs
+// are not held back by the plans' check interval
+func (f *roomCheckFixture) run(t *testing.T) *CheckReport {
+	t.Helper()
+	for _, unit := range []models.Unit{f.ok, f.down, f.broken} {
+		if check, _ := f.memory.Stores().Units.LastCheck(context.Background(), unit.ID); check != nil {
+			f.memory.SetLastCheck(unit.ID, models.UnitCheck{CheckedAt: time.Now().Add(-time.Hour), RoomCount: check.RoomCount})
+		}
+	}
+	return runRoomCheck(context.Background(), f.memory.Stores(), f.fetcher, checkcursor.All)
+}
+
+func mustParseUnitCode(t *testing.T, s string) models.UnitCode {
+	t.Helper()
+	code, err := models.ParseUnitCode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestCheckRoomsAlertsOnceForNewRoom(t *testing.T) {
+	f := newRoomCheckFixture(t)
+	room := urclient.Room{ID: "000010305", Type: "3LDK", RoomNumber: "1号棟305号室", Floor: 3, Rent: 120500}
+	other := urclient.Room{ID: "000010101", Type: "1K", RoomNumber: "1号棟101号室", Floor: 1, Rent: 70000}
+
+	// The first check only records that nothing is vacant
+	report := f.run(t)
+	if report.NewVacancies != 0 || len(f.memory.Alerts()) != 0 {
+		t.Fatalf("first run: new vacancies = %d, alerts = %v, want none", report.NewVacancies, f.memory.Alerts())
+	}
+
+	// A 3LDK and a 1K appear; only the 3LDK matches the subscription
+	f.fetcher.SetResponse(f.ok.Code, &urclient.Response{Count: 2, Room: []urclient.Room{room, other}})
+	report = f.run(t)
+	if report.NewVacancies != 2 || report.NotificationsQueued != 1 {
+		t.Errorf("second run: new vacancies = %d, queued = %d, want 2 and 1", report.NewVacancies, report.NotificationsQueued)
+	}
+
+	alerts := f.memory.Alerts()
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %v, want one", alerts)
+	}
+	alert := alerts[0]
+	if alert.UnitID != f.ok.ID || alert.UserID != "U1" || alert.SubscriptionID != f.sub.ID || len(alert.Messages) != 1 {
+		t.Errorf("alert = %+v, want one message to U1 for unit %d and subscription %d", alert, f.ok.ID, f.sub.ID)
+	}
+
+	// The same rooms are still vacant, which is not news
+	report = f.run(t)
+	if report.NewVacancies != 0 || report.NotificationsQueued != 0 || len(f.memory.Alerts()) != 1 {
+		t.Errorf("third run: new vacancies = %d, queued = %d, alerts = %d, want 0, 0 and 1",
+			report.NewVacancies, report.NotificationsQueued, len(f.memory.Alerts()))
+	}
+}
+
+func TestCheckRoomsFailsUnitsWithoutAbortingRun(t *testing.T) {
+	f := newRoomCheckFixture(t)
+
+	report := f.run(t)
+
+	if report.Error != "" {
+		t.Fatalf("Error = %q, want the run to complete", report.Error)
+	}
+	if report.Checked != 1 || report.Failed != 2 || report.Skipped != 0 {
+		t.Errorf("checked = %d, failed = %d, skipped = %d, want 1, 2 and 0", report.Checked, report.Failed, report.Skipped)
+	}
+	if got := report.StatusCode(); got != http.StatusMultiStatus {
+		t.Errorf("StatusCode() = %d, want 207", got)
+	}
+	if got := len(f.fetcher.Calls()); got != 3 {
+		t.Errorf("fetched %d units, want 3", got)
+	}
+
+	statuses := make(map[int]UnitReport)
+	for _, unit := range report.Units {
+		statuses[unit.ID] = unit
+	}
+	if got := statuses[f.ok.ID].Status; got != UnitChecked {
+		t.Errorf("unit %s status = %s, want checked", f.ok.Name, got)
+	}
+	for _, unit := range []models.Unit{f.down, f.broken} {
+		if got := statuses[unit.ID]; got.Status != UnitFailed || got.Error == "" {
+			t.Errorf("unit %s = %+v, want failed with an error", unit.Name, got)
+		}
+	}
+
+	// Failed units are not recorded as checked, and the cycle still completes
+	if check, _ := f.memory.Stores().Units.LastCheck(context.Background(), f.down.ID); check != nil {
+		t.Errorf("LastCheck(%s) = %+v, want none", f.down.Name, check)
+	}
+	if !report.CycleComplete || f.memory.Cursor(checkcursor.All).CyclesCompleted != 1 {
+		t.Errorf("cycle complete = %t, cursor = %+v, want a completed cycle", report.CycleComplete, f.memory.Cursor(checkcursor.All))
+	}
+}
+
+func TestCheckRoomsSkipsLockedShard(t *testing.T) {
+	f := newRoomCheckFixture(t)
+	release, err := f.memory.Stores().Cursors.Lock(context.Background(), checkcursor.All)
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	defer release()
+
+	report := f.run(t)
+
+	if !report.ShardSkipped || report.StatusCode() != http.StatusMultiStatus {
+		t.Errorf("shard skipped = %t, status = %d, want true and 207", report.ShardSkipped, report.StatusCode())
+	}
+	if got := len(f.fetcher.Calls()); got != 0 {
+		t.Errorf("fetched %d units, want none", got)
+	}
+}
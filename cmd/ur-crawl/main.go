@@ -0,0 +1,100 @@
+// Command ur-crawl syncs the areas, skcs and units tables with UR's property
+// listings and prints what changed.
+//
+// Usage:
+//
+//	ur-crawl [flags] [PREFECTURE...]
+//
+// Prefectures are given by UR code, e.g. 13 for Tokyo; without any, every
+// prefecture in the database is crawled. The crawl reads UR's area search
+// pages and danchi list API unless pointed elsewhere with -area-page and
+// -danchi-list.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/poprih/ur-monitor/db"
+	"github.com/poprih/ur-monitor/pkg/catalog"
+	"github.com/poprih/ur-monitor/pkg/ratelimit"
+	"github.com/poprih/ur-monitor/pkg/store"
+)
+
+func main() {
+	cfg := db.ConfigFromEnv()
+	endpoints := catalog.Endpoints{
+		AreaPage:   envString("UR_CATALOG_AREA_PAGE_URL", catalog.DefaultEndpoints.AreaPage),
+		DanchiList: envString("UR_CATALOG_DANCHI_LIST_URL", catalog.DefaultEndpoints.DanchiList),
+	}
+	rate := 2.0
+	if v, err := strconv.ParseFloat(os.Getenv("UR_API_RATE"), 64); err == nil && v > 0 {
+		rate = v
+	}
+
+	flag.StringVar(&cfg.URL, "database", cfg.URL, "Postgres connection URL (env DATABASE_URL)")
+	flag.StringVar(&endpoints.AreaPage, "area-page", endpoints.AreaPage, "URL of a prefecture's area search page, with {block} and {prefecture} (env UR_CATALOG_AREA_PAGE_URL)")
+	flag.StringVar(&endpoints.DanchiList, "danchi-list", endpoints.DanchiList, "URL of the danchi list API (env UR_CATALOG_DANCHI_LIST_URL)")
+	flag.Float64Var(&rate, "rate", rate, "listing requests per second (env UR_API_RATE)")
+	timeout := flag.Duration("timeout", 30*time.Minute, "time limit for the whole crawl")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: ur-crawl [flags] [PREFECTURE...]\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if rate <= 0 {
+		log.Fatalf("Invalid rate %v", rate)
+	}
+	source, err := catalog.NewHTTPSource(endpoints, 0, ratelimit.NewTokenBucket(rate, 1))
+	if err != nil {
+		log.Fatalf("Error creating catalog source: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	database, err := db.Open(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	defer database.Close()
+
+	report, err := catalog.Crawl(ctx, store.NewPostgres(database), source, flag.Args())
+	if err != nil {
+		log.Fatalf("Crawl failed: %v", err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, change := range report.Changes {
+			fmt.Printf("%-8s  %-12s  %s\n", change.Change, change.Code, change.Name)
+		}
+		fmt.Println(report)
+	}
+
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,144 @@
+// Command ur-migrate applies the embedded schema migrations to the database
+// at DATABASE_URL.
+//
+// Usage:
+//
+//	ur-migrate [-database URL] up
+//	ur-migrate [-database URL] down [N]
+//	ur-migrate [-database URL] to VERSION
+//	ur-migrate [-database URL] status
+//	ur-migrate [-database URL] force VERSION
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/poprih/ur-monitor/db"
+	"github.com/poprih/ur-monitor/db/migrate"
+)
+
+func main() {
+	cfg := db.ConfigFromEnv()
+	flag.StringVar(&cfg.URL, "database", cfg.URL, "Postgres connection URL (env DATABASE_URL)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	database, err := db.Open(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	defer database.Close()
+
+	migrator, err := migrate.New(database)
+	if err != nil {
+		log.Fatalf("Error loading migrations: %v", err)
+	}
+
+	if err := run(ctx, migrator, flag.Arg(0), flag.Args()[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, migrator *migrate.Migrator, command string, args []string) error {
+	switch command {
+	case "up":
+		n, err := migrator.Up(ctx)
+		fmt.Printf("Applied %d migrations\n", n)
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			var err error
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[0])
+			}
+		}
+		n, err := migrator.Down(ctx, steps)
+		fmt.Printf("Rolled back %d migrations\n", n)
+		return err
+
+	case "to":
+		version, err := versionArg(args)
+		if err != nil {
+			return err
+		}
+		n, err := migrator.To(ctx, version)
+		fmt.Printf("Ran %d migrations\n", n)
+		return err
+
+	case "force":
+		version, err := versionArg(args)
+		if err != nil {
+			return err
+		}
+		if err := migrator.Force(ctx, version); err != nil {
+			return err
+		}
+		fmt.Printf("Forced version %d\n", version)
+		return nil
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		dirty := ""
+		if status.Dirty {
+			dirty = " (dirty)"
+		}
+		fmt.Printf("Version: %d%s\n", status.Version, dirty)
+		for _, m := range status.Applied {
+			fmt.Printf("  applied  %06d_%s\n", m.Version, m.Name)
+		}
+		for _, m := range status.Pending {
+			fmt.Printf("  pending  %06d_%s\n", m.Version, m.Name)
+		}
+		return nil
+
+	default:
+		usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+func versionArg(args []string) (uint, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("missing version")
+	}
+	version, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version %q", args[0])
+	}
+	return uint(version), nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: ur-migrate [-database URL] <command>
+
+Commands:
+  up             apply all pending migrations
+  down [N]       roll back the last N migrations (default 1)
+  to VERSION     migrate up or down to VERSION (0 rolls back everything)
+  status         show applied and pending migrations
+  force VERSION  record VERSION as current and clear the dirty flag
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
@@ -0,0 +1,193 @@
+// Command ur-monitor serves the API handlers from a single long-running
+// process and, unless disabled, runs the room check on the same schedule as
+// the GitHub Actions workflow. It is meant for self-hosting outside Vercel.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/poprih/ur-monitor/api"
+	"github.com/poprih/ur-monitor/db"
+	"github.com/poprih/ur-monitor/pkg/checkcursor"
+	"github.com/poprih/ur-monitor/pkg/scheduler"
+	"github.com/poprih/ur-monitor/pkg/store"
+)
+
+type config struct {
+	addr            string
+	schedule        bool
+	timezone        string
+	windowStart     string
+	windowEnd       string
+	interval        time.Duration
+	runTimeout      time.Duration
+	shutdownTimeout time.Duration
+}
+
+func main() {
+	cfg := parseFlags()
+
+	window, err := cfg.window()
+	if err != nil {
+		log.Fatalf("Invalid schedule: %v", err)
+	}
+
+	openCtx, cancelOpen := context.WithTimeout(context.Background(), 30*time.Second)
+	database, err := db.Open(openCtx, db.ConfigFromEnv())
+	cancelOpen()
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	defer database.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/line", api.NewLineHandler(store.NewPostgres(database)))
+	mux.HandleFunc("/api/health", api.Health)
+	mux.Handle("/api/room_check", api.NewCheckRoomsHandler(database))
+	mux.Handle("/api/notification_dispatch", api.NewDispatchNotificationsHandler(database))
+	mux.Handle("/api/admin/", api.NewAdminHandler(store.NewPostgres(database)))
+
+	server := &http.Server{
+		Addr:              cfg.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	schedulerDone := make(chan struct{})
+	if cfg.schedule {
+		go func() {
+			defer close(schedulerDone)
+			scheduler.Run(ctx, window, cfg.runTimeout, func(ctx context.Context) {
+				runScheduledCheck(ctx, database)
+			})
+		}()
+	} else {
+		close(schedulerDone)
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", cfg.addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			log.Fatalf("Server failed: %v", err)
+		}
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+
+	// A room check in progress was cancelled with ctx; wait for it to save
+	// its cursor
+	select {
+	case <-schedulerDone:
+	case <-shutdownCtx.Done():
+		log.Printf("Scheduled run did not stop before the shutdown timeout")
+	}
+}
+
+// runScheduledCheck does what one run of the GitHub Actions workflow does:
+// check rooms, then deliver the alerts it queued
+func runScheduledCheck(ctx context.Context, database *sql.DB) {
+	report := api.CheckRooms(ctx, database, checkcursor.All)
+	if report.Error != "" {
+		log.Printf("Scheduled room check failed: %s", report.Error)
+	}
+
+	if _, err := api.DispatchNotifications(ctx, database); err != nil {
+		log.Printf("Error dispatching notifications: %v", err)
+	}
+}
+
+// parseFlags reads the configuration from flags, each of which defaults to
+// an environment variable
+func parseFlags() config {
+	var cfg config
+	flag.StringVar(&cfg.addr, "addr", envString("ADDR", ":"+envString("PORT", "8080")), "HTTP listen address (env ADDR or PORT)")
+	flag.BoolVar(&cfg.schedule, "schedule", envBool("SCHEDULE_ENABLED", true), "run room checks on a schedule (env SCHEDULE_ENABLED)")
+	flag.StringVar(&cfg.timezone, "timezone", envString("SCHEDULE_TIMEZONE", "Asia/Tokyo"), "time zone of the schedule window (env SCHEDULE_TIMEZONE)")
+	flag.StringVar(&cfg.windowStart, "window-start", envString("SCHEDULE_WINDOW_START", "09:00"), "first run of the day (env SCHEDULE_WINDOW_START)")
+	flag.StringVar(&cfg.windowEnd, "window-end", envString("SCHEDULE_WINDOW_END", "19:50"), "last run of the day (env SCHEDULE_WINDOW_END)")
+	flag.DurationVar(&cfg.interval, "interval", envDuration("SCHEDULE_INTERVAL", 10*time.Minute), "time between runs (env SCHEDULE_INTERVAL)")
+	flag.DurationVar(&cfg.runTimeout, "run-timeout", envDuration("SCHEDULE_RUN_TIMEOUT", 5*time.Minute), "maximum duration of a scheduled run (env SCHEDULE_RUN_TIMEOUT)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", envDuration("SHUTDOWN_TIMEOUT", 30*time.Second), "time allowed for graceful shutdown (env SHUTDOWN_TIMEOUT)")
+	flag.Parse()
+	return cfg
+}
+
+// window builds the schedule window from the configuration
+func (c config) window() (scheduler.Window, error) {
+	loc, err := time.LoadLocation(c.timezone)
+	if err != nil {
+		return scheduler.Window{}, fmt.Errorf("unknown time zone %q: %w", c.timezone, err)
+	}
+	start, err := parseClock(c.windowStart)
+	if err != nil {
+		return scheduler.Window{}, err
+	}
+	end, err := parseClock(c.windowEnd)
+	if err != nil {
+		return scheduler.Window{}, err
+	}
+
+	window := scheduler.Window{Location: loc, Start: start, End: end, Interval: c.interval}
+	return window, window.Validate()
+}
+
+// parseClock parses a time of day such as 09:00 into its offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	if b, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return b
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return def
+}
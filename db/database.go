@@ -1,13 +1,135 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
+// Config holds the connection settings for a database pool
+type Config struct {
+	URL             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// PingAttempts is how many times Open pings before giving up, waiting
+	// PingBackoff after the first failure and twice as long each time after
+	PingAttempts int
+	PingBackoff  time.Duration
+}
+
+// DefaultConfig returns settings suited to a serverless function talking to
+// Neon: a handful of connections that are recycled before Neon's pooler
+// drops them.
+func DefaultConfig() Config {
+	return Config{
+		MaxOpenConns:    5,
+		MaxIdleConns:    2,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+		PingAttempts:    3,
+		PingBackoff:     500 * time.Millisecond,
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig overridden by DATABASE_URL,
+// DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME and
+// DB_CONN_MAX_IDLE_TIME
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	cfg.URL = os.Getenv("DATABASE_URL")
+	if n, err := strconv.Atoi(os.Getenv("DB_MAX_OPEN_CONNS")); err == nil {
+		cfg.MaxOpenConns = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("DB_MAX_IDLE_CONNS")); err == nil {
+		cfg.MaxIdleConns = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("DB_CONN_MAX_LIFETIME")); err == nil {
+		cfg.ConnMaxLifetime = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("DB_CONN_MAX_IDLE_TIME")); err == nil {
+		cfg.ConnMaxIdleTime = d
+	}
+	return cfg
+}
+
+// Open creates a connection pool and pings the database until it answers,
+// so a bad DATABASE_URL is reported up front rather than on the first query
+func Open(ctx context.Context, cfg Config) (*sql.DB, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("database URL is not set")
+	}
+
+	db, err := sql.Open("postgres", cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	backoff := cfg.PingBackoff
+	for attempt := 1; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= cfg.PingAttempts {
+			break
+		}
+
+		log.Printf("Database ping failed (attempt %d/%d): %v", attempt, cfg.PingAttempts, err)
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("database ping cancelled: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	db.Close()
+	return nil, fmt.Errorf("database ping failed after %d attempts: %w", cfg.PingAttempts, err)
+}
+
+var (
+	poolMu sync.Mutex
+	pool   *sql.DB
+)
+
+// Pool returns the process-wide connection pool, opening it from the
+// environment on first use. Warm serverless invocations reuse it. A failed
+// open is not cached, so the next call tries again. Callers must not close
+// the returned pool.
+func Pool(ctx context.Context) (*sql.DB, error) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if pool != nil {
+		return pool, nil
+	}
+
+	db, err := Open(ctx, ConfigFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	pool = db
+	return pool, nil
+}
+
+// ConnectDB opens a new, unpooled connection to DATABASE_URL.
+//
+// Deprecated: Use Pool, or Open for a pool owned by the caller.
 func ConnectDB() (*sql.DB, error) {
 	dbURL := os.Getenv("DATABASE_URL")
 	db, err := sql.Open("postgres", dbURL)
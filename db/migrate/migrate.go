@@ -0,0 +1,276 @@
+// Package migrate applies the SQL files in db/migrations to a Postgres
+// database.
+//
+// Progress is tracked in the same schema_migrations table golang-migrate
+// uses, holding the current version and a dirty flag, so databases that were
+// migrated with the golang-migrate CLI can switch to this runner and back.
+// Each migration runs in a transaction together with the version update.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/poprih/ur-monitor/db/migrations"
+)
+
+// lockID is the Postgres advisory lock key held while migrating, so that
+// concurrent deploys don't apply the same migration twice
+const lockID = 7_252_019_001
+
+// ErrDirty is returned when a previous run left the schema in an unknown
+// state. Fix the database by hand, then use Force to record its version.
+var ErrDirty = errors.New("database is dirty")
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New returns a Migrator for the migrations embedded from db/migrations
+func New(db *sql.DB) (*Migrator, error) {
+	list, err := Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithMigrations(db, list), nil
+}
+
+// NewWithMigrations returns a Migrator for the given migrations, which must
+// be ordered by version
+func NewWithMigrations(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Status describes the schema version of a database
+type Status struct {
+	// Version is the last applied migration, or 0 if none has been
+	Version uint
+	Dirty   bool
+	Applied []Migration
+	Pending []Migration
+}
+
+// Status reports which migrations have been applied
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	var status *Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		status = &Status{Version: version, Dirty: dirty}
+		for _, migration := range m.migrations {
+			if migration.Version <= version {
+				status.Applied = append(status.Applied, migration)
+			} else {
+				status.Pending = append(status.Pending, migration)
+			}
+		}
+		return nil
+	})
+	return status, err
+}
+
+// Up applies every pending migration and returns how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if len(m.migrations) == 0 {
+		return 0, nil
+	}
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down rolls back the last steps applied migrations and returns how many
+// were rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, err := cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for ; count < steps && version > 0; count++ {
+			if version, err = m.stepDown(ctx, conn, version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// To migrates up or down until target is the last applied migration and
+// returns how many migrations were run. A target of 0 rolls back everything.
+func (m *Migrator) To(ctx context.Context, target uint) (int, error) {
+	if target != 0 && m.index(target) < 0 {
+		return 0, fmt.Errorf("no migration with version %d", target)
+	}
+
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, err := cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= version || migration.Version > target {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration.Up, migration.Version); err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+
+		for version > target {
+			if version, err = m.stepDown(ctx, conn, version); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Force records version as the current one and clears the dirty flag
+// without running any migration
+func (m *Migrator) Force(ctx context.Context, version uint) error {
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("no migration with version %d", version)
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := setVersion(ctx, tx, version); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// stepDown rolls back the migration at version and returns the new current
+// version
+func (m *Migrator) stepDown(ctx context.Context, conn *sql.Conn, version uint) (uint, error) {
+	i := m.index(version)
+	if i < 0 {
+		return 0, fmt.Errorf("database is at version %d, which has no migration file", version)
+	}
+
+	migration := m.migrations[i]
+	if migration.Down == "" {
+		return 0, fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+	}
+
+	var previous uint
+	if i > 0 {
+		previous = m.migrations[i-1].Version
+	}
+	if err := m.apply(ctx, conn, migration.Down, previous); err != nil {
+		return 0, fmt.Errorf("rolling back migration %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+	return previous, nil
+}
+
+// apply runs body and records version in one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, body string, version uint) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if err := setVersion(ctx, tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// index returns the position of version in the migration list, or -1
+func (m *Migrator) index(version uint) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// withLock runs fn on a single connection holding the migration advisory
+// lock, creating the schema table first if needed
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockID)
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			dirty BOOLEAN NOT NULL
+		)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// currentVersion reads the recorded schema version
+func currentVersion(ctx context.Context, conn *sql.Conn) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return uint(version), dirty, nil
+}
+
+// cleanVersion reads the recorded schema version and fails if it is dirty
+func cleanVersion(ctx context.Context, conn *sql.Conn) (uint, error) {
+	version, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, fmt.Errorf("%w at version %d", ErrDirty, version)
+	}
+	return version, nil
+}
+
+// setVersion replaces the recorded schema version. Version 0 means no
+// migration has been applied and is stored as an empty table.
+func setVersion(ctx context.Context, tx *sql.Tx, version uint) error {
+	if _, err := tx.ExecContext(ctx, "TRUNCATE schema_migrations"); err != nil {
+		return fmt.Errorf("failed to clear schema version: %w", err)
+	}
+	if version == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, FALSE)", int64(version)); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/poprih/ur-monitor/db"
+)
+
+// openTestSchema opens DATABASE_URL with a new, empty schema first on the
+// search path, or skips the test if it is unset. The schema is dropped
+// afterwards, so tests can migrate from nothing without touching the
+// database's own tables.
+func openTestSchema(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := db.Open(ctx, db.ConfigFromEnv())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.ExecContext(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	})
+
+	// public stays on the path for extensions such as pg_trgm that are
+	// already installed there
+	cfg := db.ConfigFromEnv()
+	cfg.URL = withSearchPath(dsn, schema+",public")
+	database, err := db.Open(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Open() with search path error = %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+// withSearchPath adds a search_path parameter to a URL or key=value DSN
+func withSearchPath(dsn, path string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		query := u.Query()
+		query.Set("search_path", path)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + path
+}
+
+// tableExists reports whether name is a table in the first schema on the
+// search path
+func tableExists(t *testing.T, database *sql.DB, name string) bool {
+	t.Helper()
+	var exists bool
+	err := database.QueryRowContext(context.Background(),
+		"SELECT to_regclass(current_schema() || '.' || $1) IS NOT NULL", name).Scan(&exists)
+	if err != nil {
+		t.Fatalf("failed to look up table %s: %v", name, err)
+	}
+	return exists
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		{Version: 1, Name: "create_a", Up: "CREATE TABLE a (id INTEGER)", Down: "DROP TABLE a"},
+		{Version: 2, Name: "create_b", Up: "CREATE TABLE b (id INTEGER)", Down: "DROP TABLE b"},
+		{Version: 5, Name: "create_c", Up: "CREATE TABLE c (id INTEGER)", Down: "DROP TABLE c"},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000002_second.up.sql":   {Data: []byte("SELECT 2")},
+		"000002_second.down.sql": {Data: []byte("SELECT -2")},
+		"000010_third.up.sql":    {Data: []byte("SELECT 10")},
+		"000001_first.up.sql":    {Data: []byte("SELECT 1")},
+		"README.md":              {Data: []byte("not a migration")},
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	var got []string
+	for _, m := range migrations {
+		got = append(got, fmt.Sprintf("%d_%s up=%q down=%q", m.Version, m.Name, m.Up, m.Down))
+	}
+	want := []string{
+		`1_first up="SELECT 1" down=""`,
+		`2_second up="SELECT 2" down="SELECT -2"`,
+		`10_third up="SELECT 10" down=""`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Load() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadRejectsInvalidMigrations(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"down without up", fstest.MapFS{"000001_first.down.sql": {Data: []byte("SELECT 1")}}},
+		{"two names", fstest.MapFS{
+			"000001_first.up.sql": {Data: []byte("SELECT 1")},
+			"000001_other.up.sql": {Data: []byte("SELECT 1")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(tt.fsys); err == nil {
+				t.Error("Load() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	if _, err := New(nil); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+}
+
+func TestUpAppliesEmbeddedMigrations(t *testing.T) {
+	database := openTestSchema(t)
+	ctx := context.Background()
+
+	migrator, err := New(database)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	latest := migrator.migrations[len(migrator.migrations)-1].Version
+
+	n, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if n != len(migrator.migrations) {
+		t.Errorf("Up() applied %d migrations, want %d", n, len(migrator.migrations))
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Version != latest || status.Dirty || len(status.Pending) != 0 {
+		t.Errorf("Status() = version %d, dirty %t, %d pending, want version %d, clean, none pending",
+			status.Version, status.Dirty, len(status.Pending), latest)
+	}
+
+	if n, err := migrator.Up(ctx); err != nil || n != 0 {
+		t.Errorf("second Up() = %d, %v, want 0 and no error", n, err)
+	}
+
+	// Every down file runs too
+	if _, err := migrator.To(ctx, 0); err != nil {
+		t.Fatalf("To(0) error = %v", err)
+	}
+	if tableExists(t, database, "units") {
+		t.Error("units still exists after rolling back every migration")
+	}
+	if n, err := migrator.Up(ctx); err != nil || n != len(migrator.migrations) {
+		t.Errorf("Up() after rolling back = %d, %v, want %d", n, err, len(migrator.migrations))
+	}
+}
+
+func TestSchemaMigrationsMatchesGolangMigrate(t *testing.T) {
+	database := openTestSchema(t)
+	ctx := context.Background()
+	migrator := NewWithMigrations(database, testMigrations())
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+
+	// golang-migrate keeps a single row of version and dirty
+	rows, err := database.QueryContext(ctx, "SELECT version, dirty FROM schema_migrations")
+	if err != nil {
+		t.Fatalf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var version int64
+		var dirty bool
+		if err := rows.Scan(&version, &dirty); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d %t", version, dirty))
+	}
+	if fmt.Sprint(got) != "[5 false]" {
+		t.Errorf("schema_migrations = %v, want one row of 5 false", got)
+	}
+}
+
+func TestUpKeepsVersionWhenMigrationFails(t *testing.T) {
+	database := openTestSchema(t)
+	ctx := context.Background()
+	migrations := append(testMigrations()[:2], Migration{
+		Version: 3, Name: "broken",
+		Up: "CREATE TABLE broken (id INTEGER); SELECT * FROM missing_table",
+	})
+	migrator := NewWithMigrations(database, migrations)
+
+	if _, err := migrator.Up(ctx); err == nil {
+		t.Fatal("Up() error = nil, want the failing migration's error")
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Version != 2 || status.Dirty {
+		t.Errorf("Status() = version %d, dirty %t, want version 2, clean", status.Version, status.Dirty)
+	}
+	if tableExists(t, database, "broken") {
+		t.Error("the failed migration's table was kept")
+	}
+
+	// The fixed migration applies on the next run
+	migrations[2].Up = "CREATE TABLE broken (id INTEGER)"
+	if n, err := migrator.Up(ctx); err != nil || n != 1 {
+		t.Errorf("Up() after fixing = %d, %v, want 1 and no error", n, err)
+	}
+}
+
+func TestDownAndTo(t *testing.T) {
+	database := openTestSchema(t)
+	ctx := context.Background()
+	migrator := NewWithMigrations(database, testMigrations())
+
+	if _, err := migrator.To(ctx, 2); err != nil {
+		t.Fatalf("To(2) error = %v", err)
+	}
+	if !tableExists(t, database, "b") || tableExists(t, database, "c") {
+		t.Error("To(2) did not stop at version 2")
+	}
+
+	if n, err := migrator.Up(ctx); err != nil || n != 1 {
+		t.Fatalf("Up() = %d, %v, want 1", n, err)
+	}
+	if n, err := migrator.Down(ctx, 2); err != nil || n != 2 {
+		t.Fatalf("Down(2) = %d, %v, want 2", n, err)
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Version != 1 || tableExists(t, database, "b") || tableExists(t, database, "c") {
+		t.Errorf("after Down(2): version %d, want 1 with b and c dropped", status.Version)
+	}
+
+	if _, err := migrator.To(ctx, 3); err == nil {
+		t.Error("To(3) error = nil, want an error for a missing version")
+	}
+	if n, err := migrator.Down(ctx, 5); err != nil || n != 1 {
+		t.Errorf("Down(5) = %d, %v, want 1", n, err)
+	}
+}
+
+func TestDirtyDatabaseNeedsForce(t *testing.T) {
+	database := openTestSchema(t)
+	ctx := context.Background()
+	migrator := NewWithMigrations(database, testMigrations())
+
+	if _, err := migrator.To(ctx, 1); err != nil {
+		t.Fatalf("To(1) error = %v", err)
+	}
+	// As golang-migrate leaves a database whose migration failed halfway
+	if _, err := database.ExecContext(ctx, "UPDATE schema_migrations SET version = 2, dirty = TRUE"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(ctx); !errors.Is(err, ErrDirty) {
+		t.Fatalf("Up() error = %v, want ErrDirty", err)
+	}
+	if _, err := migrator.Down(ctx, 1); !errors.Is(err, ErrDirty) {
+		t.Errorf("Down() error = %v, want ErrDirty", err)
+	}
+
+	if err := migrator.Force(ctx, 1); err != nil {
+		t.Fatalf("Force(1) error = %v", err)
+	}
+	if n, err := migrator.Up(ctx); err != nil || n != 2 {
+		t.Errorf("Up() after Force(1) = %d, %v, want 2", n, err)
+	}
+	if err := migrator.Force(ctx, 4); err == nil {
+		t.Error("Force(4) error = nil, want an error for a missing version")
+	}
+}
+
+func TestConcurrentUpAppliesEachMigrationOnce(t *testing.T) {
+	database := openTestSchema(t)
+	ctx := context.Background()
+
+	// Without the lock both runs would see version 0 and the second would
+	// fail creating a table that exists
+	const runs = 4
+	counts := make([]int, runs)
+	errs := make([]error, runs)
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts[i], errs[i] = NewWithMigrations(database, testMigrations()).Up(ctx)
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range counts {
+		if errs[i] != nil {
+			t.Errorf("Up() error = %v", errs[i])
+		}
+		total += counts[i]
+	}
+	if total != len(testMigrations()) {
+		t.Errorf("concurrent Up() applied %d migrations in total, want %d", total, len(testMigrations()))
+	}
+}
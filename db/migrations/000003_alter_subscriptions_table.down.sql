@@ -1,2 +1,2 @@
 ALTER TABLE subscriptions
-DROP COLUMN expires_at; 
+DROP COLUMN IF EXISTS expires_at; 
@@ -1,4 +1,5 @@
+DROP INDEX IF EXISTS idx_units_area_id;
+
 ALTER TABLE units
 DROP CONSTRAINT IF EXISTS fk_area,
-DROP INDEX IF EXISTS idx_units_area_id,
-DROP COLUMN IF EXISTS area_id; 
+DROP COLUMN IF EXISTS area_id;
@@ -1,8 +1,19 @@
-ALTER TABLE units 
-ADD COLUMN IF NOT EXISTS area_id INTEGER,
-ADD CONSTRAINT fk_area
-    FOREIGN KEY (area_id)
-    REFERENCES areas(id)
-    ON DELETE CASCADE;
+-- 000009 already adds area_id and fk_area, so only add what is missing
+ALTER TABLE units
+ADD COLUMN IF NOT EXISTS area_id INTEGER;
 
-CREATE INDEX IF NOT EXISTS idx_units_area_id ON units(area_id); 
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname = 'fk_area' AND conrelid = 'units'::regclass
+    ) THEN
+        ALTER TABLE units
+        ADD CONSTRAINT fk_area
+            FOREIGN KEY (area_id)
+            REFERENCES areas(id)
+            ON DELETE CASCADE;
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_units_area_id ON units(area_id);
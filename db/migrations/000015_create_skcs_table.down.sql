@@ -1,7 +1,5 @@
--- Drop skcs table and its dependencies
-DROP TABLE IF EXISTS skcs;
-
--- Revert units table to reference areas
+-- Revert units table to reference areas. fk_skc goes first, or skcs
+-- cannot be dropped.
 ALTER TABLE units 
     DROP CONSTRAINT IF EXISTS fk_skc,
     DROP COLUMN IF EXISTS skc_id,
@@ -13,3 +11,6 @@ ALTER TABLE units
 
 -- Recreate index for area_id
 CREATE INDEX IF NOT EXISTS idx_units_area_id ON units(area_id); 
+
+-- Drop skcs table and its dependencies
+DROP TABLE IF EXISTS skcs;
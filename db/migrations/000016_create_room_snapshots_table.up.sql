@@ -0,0 +1,14 @@
+-- Each row is a period during which a unit's vacant rooms stayed the same.
+-- checked_at is bumped on every check that sees the same rooms again.
+CREATE TABLE room_snapshots (
+    id SERIAL PRIMARY KEY,
+    unit_id INTEGER NOT NULL REFERENCES units(id) ON DELETE CASCADE,
+    room_count INTEGER NOT NULL DEFAULT 0,
+    rooms JSONB NOT NULL DEFAULT '[]',
+    appeared JSONB NOT NULL DEFAULT '[]',
+    disappeared JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    checked_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_room_snapshots_unit_id_id ON room_snapshots(unit_id, id DESC);
@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions
+DROP CONSTRAINT IF EXISTS subscriptions_mode_check,
+DROP COLUMN IF EXISTS mode;
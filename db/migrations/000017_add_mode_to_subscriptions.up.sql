@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions
+ADD COLUMN mode VARCHAR(20) NOT NULL DEFAULT 'oneshot',
+ADD CONSTRAINT subscriptions_mode_check CHECK (mode IN ('oneshot', 'persistent'));
@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions
+DROP COLUMN IF EXISTS max_rent,
+DROP COLUMN IF EXISTS min_floor_area,
+DROP COLUMN IF EXISTS min_floor,
+DROP COLUMN IF EXISTS max_floor;
@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions
+ADD COLUMN max_rent INTEGER,
+ADD COLUMN min_floor_area NUMERIC(6, 2),
+ADD COLUMN min_floor INTEGER,
+ADD COLUMN max_floor INTEGER;
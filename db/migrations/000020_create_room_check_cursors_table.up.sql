@@ -0,0 +1,16 @@
+CREATE TABLE room_check_cursors (
+    shard_index INTEGER NOT NULL,
+    shard_count INTEGER NOT NULL,
+    last_unit_id INTEGER NOT NULL DEFAULT 0,
+    cycles_completed INTEGER NOT NULL DEFAULT 0,
+    cycle_started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (shard_index, shard_count),
+    CONSTRAINT room_check_cursors_shard_check CHECK (shard_index >= 0 AND shard_index < shard_count)
+);
+
+CREATE TRIGGER update_room_check_cursors_updated_at
+    BEFORE UPDATE ON room_check_cursors
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
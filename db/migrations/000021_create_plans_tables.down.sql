@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN is_premium BOOLEAN DEFAULT FALSE;
+
+UPDATE users SET is_premium = TRUE
+WHERE line_user_id IN (
+    SELECT up.line_user_id FROM user_plans up
+    JOIN plans p ON p.id = up.plan_id
+    WHERE p.code = 'premium' AND (up.expires_at IS NULL OR up.expires_at > NOW())
+);
+
+DROP TABLE IF EXISTS plan_changes;
+DROP TABLE IF EXISTS user_plans;
+DROP TABLE IF EXISTS plans;
@@ -0,0 +1,66 @@
+CREATE TABLE plans (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) NOT NULL UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    -- NULL means unlimited
+    max_subscriptions INTEGER,
+    max_room_types INTEGER,
+    check_interval_minutes INTEGER NOT NULL DEFAULT 10,
+    allow_persistent BOOLEAN NOT NULL DEFAULT TRUE,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT plans_check_interval_check CHECK (check_interval_minutes > 0)
+);
+
+-- Users without an active assignment are on the default plan
+CREATE UNIQUE INDEX idx_plans_default ON plans(is_default) WHERE is_default;
+
+CREATE TRIGGER update_plans_updated_at
+    BEFORE UPDATE ON plans
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TABLE user_plans (
+    line_user_id TEXT PRIMARY KEY REFERENCES users(line_user_id) ON DELETE CASCADE,
+    plan_id INTEGER NOT NULL REFERENCES plans(id),
+    -- NULL means the assignment never expires
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_user_plans_updated_at
+    BEFORE UPDATE ON user_plans
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Audit log of plan assignments. It has no foreign key to users so that the
+-- history outlives the user.
+CREATE TABLE plan_changes (
+    id SERIAL PRIMARY KEY,
+    line_user_id TEXT NOT NULL,
+    old_plan_id INTEGER REFERENCES plans(id),
+    new_plan_id INTEGER NOT NULL REFERENCES plans(id),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    changed_by TEXT NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_plan_changes_line_user_id ON plan_changes(line_user_id);
+
+INSERT INTO plans (code, name, max_subscriptions, max_room_types, check_interval_minutes, allow_persistent, is_default) VALUES
+    ('free', 'Free', 1, NULL, 10, TRUE, TRUE),
+    ('premium', 'Premium', NULL, NULL, 10, TRUE, FALSE);
+
+-- Carry over existing premium users
+INSERT INTO user_plans (line_user_id, plan_id)
+SELECT u.line_user_id, p.id FROM users u, plans p
+WHERE u.is_premium AND p.code = 'premium';
+
+INSERT INTO plan_changes (line_user_id, new_plan_id, changed_by, reason)
+SELECT line_user_id, plan_id, 'migration', 'users.is_premium'
+FROM user_plans;
+
+ALTER TABLE users DROP COLUMN is_premium;
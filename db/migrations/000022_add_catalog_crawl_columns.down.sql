@@ -0,0 +1,5 @@
+ALTER TABLE units
+DROP COLUMN IF EXISTS delisted_at;
+
+ALTER TABLE skcs
+DROP CONSTRAINT IF EXISTS skcs_area_id_ur_id_unique;
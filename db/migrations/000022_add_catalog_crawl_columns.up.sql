@@ -0,0 +1,9 @@
+-- The catalog crawler upserts skcs by their UR id within an area
+ALTER TABLE skcs
+ADD CONSTRAINT skcs_area_id_ur_id_unique UNIQUE (area_id, ur_id);
+
+-- Units that disappear from UR's listings are marked rather than deleted so
+-- that their subscriptions and snapshots survive a bad crawl. They are
+-- cleared again when the unit is listed again.
+ALTER TABLE units
+ADD COLUMN delisted_at TIMESTAMP WITH TIME ZONE;
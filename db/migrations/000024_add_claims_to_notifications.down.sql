@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_notifications_claimed;
+
+UPDATE notifications SET status = 'pending' WHERE status = 'sending';
+
+ALTER TABLE notifications
+DROP CONSTRAINT notifications_status_check,
+ADD CONSTRAINT notifications_status_check CHECK (status IN ('pending', 'sent', 'failed'));
+
+ALTER TABLE notifications
+DROP COLUMN IF EXISTS claimed_until;
@@ -0,0 +1,12 @@
+-- The dispatcher claims a batch by marking it 'sending' until claimed_until
+-- and commits before calling LINE, so no row lock is held during requests.
+-- Claims that outlive claimed_until belong to a dispatcher that died and are
+-- picked up again.
+ALTER TABLE notifications
+ADD COLUMN claimed_until TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE notifications
+DROP CONSTRAINT notifications_status_check,
+ADD CONSTRAINT notifications_status_check CHECK (status IN ('pending', 'sending', 'sent', 'failed'));
+
+CREATE INDEX idx_notifications_claimed ON notifications(claimed_until) WHERE status = 'sending';
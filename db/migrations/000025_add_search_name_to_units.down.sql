@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_units_search_name_trgm;
+DROP INDEX IF EXISTS idx_units_search_name;
+
+ALTER TABLE units
+DROP COLUMN IF EXISTS search_name;
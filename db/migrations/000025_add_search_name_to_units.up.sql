@@ -0,0 +1,11 @@
+-- Unit lookups from chat match the normalized name (unitsearch.Normalize) in
+-- SQL rather than loading every unit. The application fills search_name in,
+-- as the normalization is not expressible in SQL; rows still without one are
+-- backfilled by the lookups themselves.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE units
+ADD COLUMN search_name TEXT;
+
+CREATE INDEX idx_units_search_name ON units(search_name);
+CREATE INDEX idx_units_search_name_trgm ON units USING GIN (search_name gin_trgm_ops);
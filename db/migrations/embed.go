@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migration files so binaries can apply
+// them without the source tree.
+package migrations
+
+import "embed"
+
+// FS holds every NNNNNN_name.up.sql and NNNNNN_name.down.sql file
+//
+//go:embed *.sql
+var FS embed.FS
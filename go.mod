@@ -3,3 +3,5 @@ module github.com/poprih/ur-monitor
 go 1.22.3
 
 require github.com/lib/pq v1.10.9
+
+require golang.org/x/text v0.22.0
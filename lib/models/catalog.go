@@ -0,0 +1,26 @@
+package models
+
+// Prefecture is a prefecture the catalog is crawled from. Prefectures are
+// seeded by migration rather than crawled.
+type Prefecture struct {
+	ID     int
+	Name   string
+	URCode string
+	// Code is the romanized name, e.g. TOKYO
+	Code string
+	// Region is the code of the prefecture's region, e.g. KANTO
+	Region string
+}
+
+// Area is a group of municipalities within a prefecture on the UR site
+type Area struct {
+	Code string
+	Name string
+}
+
+// SKC is a municipality (shikuchoson) within an area
+type SKC struct {
+	URID int
+	Code string
+	Name string
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Plan is a subscription tier limiting what its users can set up
+type Plan struct {
+	ID   int
+	Code string
+	Name string
+	// MaxSubscriptions and MaxRoomTypes are nil when unlimited
+	MaxSubscriptions *int
+	MaxRoomTypes     *int
+	// CheckInterval is how often the units its users watch are checked
+	CheckInterval   time.Duration
+	AllowPersistent bool
+	// IsDefault marks the plan of users without an active assignment
+	IsDefault bool
+}
+
+// UserPlan is the plan a user is currently on
+type UserPlan struct {
+	LineUserID string
+	Plan       Plan
+	// ExpiresAt is nil for the default plan and for assignments that
+	// never expire
+	ExpiresAt *time.Time
+}
+
+// PlanChange is an audit record of a plan assignment
+type PlanChange struct {
+	ID         int
+	LineUserID string
+	// OldPlanCode is empty if the user had no assignment before
+	OldPlanCode string
+	NewPlanCode string
+	ExpiresAt   *time.Time
+	ChangedBy   string
+	Reason      string
+	CreatedAt   time.Time
+}
+
+// Limits reported by Plan.Permits
+var (
+	ErrSubscriptionLimit    = errors.New("subscription limit reached")
+	ErrRoomTypeLimit        = errors.New("room type limit reached")
+	ErrPersistentNotAllowed = errors.New("persistent subscriptions not allowed")
+)
+
+// PlanLimitError is returned when a plan does not permit a subscription. It
+// wraps one of the limit errors above.
+type PlanLimitError struct {
+	Err error
+	// Limit is the maximum the plan allows, for count limits
+	Limit int
+}
+
+func (e *PlanLimitError) Error() string {
+	if errors.Is(e.Err, ErrPersistentNotAllowed) {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (limit %d)", e.Err, e.Limit)
+}
+
+func (e *PlanLimitError) Unwrap() error {
+	return e.Err
+}
+
+// Permits reports whether a user on the plan who has active subscriptions
+// may save sub, returning a *PlanLimitError if not
+func (p Plan) Permits(sub Subscription, active int) error {
+	if p.MaxSubscriptions != nil && active >= *p.MaxSubscriptions {
+		return &PlanLimitError{Err: ErrSubscriptionLimit, Limit: *p.MaxSubscriptions}
+	}
+	if p.MaxRoomTypes != nil && len(sub.RoomTypes) > *p.MaxRoomTypes {
+		return &PlanLimitError{Err: ErrRoomTypeLimit, Limit: *p.MaxRoomTypes}
+	}
+	if sub.Mode == SubscriptionModePersistent && !p.AllowPersistent {
+		return &PlanLimitError{Err: ErrPersistentNotAllowed}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubscriptionMode controls what happens to a subscription after a vacancy notification
+type SubscriptionMode string
+
+const (
+	// SubscriptionModeOneShot subscriptions are removed after the first notification
+	SubscriptionModeOneShot SubscriptionMode = "oneshot"
+	// SubscriptionModePersistent subscriptions stay active until the user unsubscribes
+	SubscriptionModePersistent SubscriptionMode = "persistent"
+)
+
+// Subscription is a user's request to be told about vacancies in a unit
+type Subscription struct {
+	ID         int
+	LineUserID string
+	UnitID     int
+	// UnitName is filled in when subscriptions are listed
+	UnitName  string
+	RoomTypes []string
+	Mode      SubscriptionMode
+	Filters   SubscriptionFilters
+	CreatedAt time.Time
+}
+
+// ValidateRoomTypes checks the room types of a subscription: each must be
+// non-blank, must not contain the ":", "&" or "＆" separators of the chat
+// syntax and must not be repeated. An empty list means any room type.
+func ValidateRoomTypes(roomTypes []string) error {
+	seen := make(map[string]bool, len(roomTypes))
+	for _, roomType := range roomTypes {
+		switch {
+		case strings.TrimSpace(roomType) == "":
+			return errors.New("empty room type")
+		case strings.ContainsAny(roomType, ":&＆"):
+			return fmt.Errorf("invalid room type %q", roomType)
+		case seen[roomType]:
+			return fmt.Errorf("duplicate room type %q", roomType)
+		}
+		seen[roomType] = true
+	}
+	return nil
+}
+
+// SubscriptionFilters are the optional room conditions of a subscription.
+// A nil field means the condition is not set.
+type SubscriptionFilters struct {
+	MaxRent      *int     `json:"max_rent,omitempty"`
+	MinFloorArea *float64 `json:"min_floor_area,omitempty"`
+	MinFloor     *int     `json:"min_floor,omitempty"`
+	MaxFloor     *int     `json:"max_floor,omitempty"`
+}
+
+// IsEmpty reports whether no filter is set
+func (f SubscriptionFilters) IsEmpty() bool {
+	return f.MaxRent == nil && f.MinFloorArea == nil && f.MinFloor == nil && f.MaxFloor == nil
+}
+
+// Validate checks that every filter that is set is non-negative and that
+// the floor range is not inverted
+func (f SubscriptionFilters) Validate() error {
+	switch {
+	case f.MaxRent != nil && *f.MaxRent < 0:
+		return fmt.Errorf("rent<=%d must not be negative", *f.MaxRent)
+	case f.MinFloorArea != nil && *f.MinFloorArea < 0:
+		return fmt.Errorf("area>=%s must not be negative", strconv.FormatFloat(*f.MinFloorArea, 'f', -1, 64))
+	case f.MinFloor != nil && *f.MinFloor < 0:
+		return fmt.Errorf("floor>=%d must not be negative", *f.MinFloor)
+	case f.MaxFloor != nil && *f.MaxFloor < 0:
+		return fmt.Errorf("floor<=%d must not be negative", *f.MaxFloor)
+	case f.MinFloor != nil && f.MaxFloor != nil && *f.MinFloor > *f.MaxFloor:
+		return fmt.Errorf("floor>=%d is above floor<=%d", *f.MinFloor, *f.MaxFloor)
+	}
+	return nil
+}
+
+// Allows reports whether a room with the given rent (yen), floor area (㎡)
+// and floor passes the filters. Zero values mean the UR API did not report
+// the value, and unknown values never exclude a room.
+func (f SubscriptionFilters) Allows(rent int, floorArea float64, floor int) bool {
+	if f.MaxRent != nil && rent > 0 && rent > *f.MaxRent {
+		return false
+	}
+	if f.MinFloorArea != nil && floorArea > 0 && floorArea < *f.MinFloorArea {
+		return false
+	}
+	if f.MinFloor != nil && floor > 0 && floor < *f.MinFloor {
+		return false
+	}
+	if f.MaxFloor != nil && floor > 0 && floor > *f.MaxFloor {
+		return false
+	}
+	return true
+}
+
+// String renders the filters in the same syntax users type in chat,
+// e.g. "rent<=150000, area>=60, floor>=3"
+func (f SubscriptionFilters) String() string {
+	var parts []string
+	if f.MaxRent != nil {
+		parts = append(parts, fmt.Sprintf("rent<=%d", *f.MaxRent))
+	}
+	if f.MinFloorArea != nil {
+		parts = append(parts, fmt.Sprintf("area>=%s", strconv.FormatFloat(*f.MinFloorArea, 'f', -1, 64)))
+	}
+	if f.MinFloor != nil && f.MaxFloor != nil && *f.MinFloor == *f.MaxFloor {
+		parts = append(parts, fmt.Sprintf("floor=%d", *f.MinFloor))
+	} else {
+		if f.MinFloor != nil {
+			parts = append(parts, fmt.Sprintf("floor>=%d", *f.MinFloor))
+		}
+		if f.MaxFloor != nil {
+			parts = append(parts, fmt.Sprintf("floor<=%d", *f.MaxFloor))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidUnitCode is wrapped by the errors ParseUnitCode and
+// UnitCode.Validate return
+var ErrInvalidUnitCode = errors.New("invalid unit code")
+
+// danchiLength is the number of digits of the danchi part of a unit code
+const danchiLength = 3
+
+// UnitCode identifies a property in the UR API. It is stored in
+// units.unit_code as shisya+"_"+danchi+shikibetu, e.g. "20_1230" for shisya
+// "20", danchi "123" and shikibetu "0", and marshals to JSON the same way.
+// The zero UnitCode is a unit without a code, stored as NULL.
+type UnitCode struct {
+	Shisya    string
+	Danchi    string
+	Shikibetu string
+}
+
+// ParseUnitCode parses a unit code in the units.unit_code format
+func ParseUnitCode(s string) (UnitCode, error) {
+	shisya, rest, ok := strings.Cut(s, "_")
+	if !ok {
+		return UnitCode{}, fmt.Errorf("%w %q: missing \"_\"", ErrInvalidUnitCode, s)
+	}
+	if len(rest) <= danchiLength {
+		return UnitCode{}, fmt.Errorf("%w %q: expected %d danchi digits and a shikibetu after \"_\"", ErrInvalidUnitCode, s, danchiLength)
+	}
+
+	code := UnitCode{Shisya: shisya, Danchi: rest[:danchiLength], Shikibetu: rest[danchiLength:]}
+	if problem := code.problem(); problem != "" {
+		return UnitCode{}, fmt.Errorf("%w %q: %s", ErrInvalidUnitCode, s, problem)
+	}
+	return code, nil
+}
+
+// Validate reports whether every part is made of digits and the danchi has
+// exactly three of them
+func (c UnitCode) Validate() error {
+	if problem := c.problem(); problem != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidUnitCode, problem)
+	}
+	return nil
+}
+
+// problem describes the first invalid part of the code, or is empty
+func (c UnitCode) problem() string {
+	switch {
+	case !isDigits(c.Shisya):
+		return fmt.Sprintf("shisya %q is not a number", c.Shisya)
+	case len(c.Danchi) != danchiLength || !isDigits(c.Danchi):
+		return fmt.Sprintf("danchi %q is not %d digits", c.Danchi, danchiLength)
+	case !isDigits(c.Shikibetu):
+		return fmt.Sprintf("shikibetu %q is not a number", c.Shikibetu)
+	}
+	return ""
+}
+
+// IsZero reports whether the code is unset
+func (c UnitCode) IsZero() bool {
+	return c == UnitCode{}
+}
+
+// String formats the code like units.unit_code, or returns "" for the zero
+// UnitCode
+func (c UnitCode) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return c.Shisya + "_" + c.Danchi + c.Shikibetu
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (c UnitCode) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. "" is the zero
+// UnitCode.
+func (c *UnitCode) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*c = UnitCode{}
+		return nil
+	}
+	code, err := ParseUnitCode(string(text))
+	if err != nil {
+		return err
+	}
+	*c = code
+	return nil
+}
+
+// Scan implements sql.Scanner. NULL and "" scan as the zero UnitCode.
+func (c *UnitCode) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*c = UnitCode{}
+		return nil
+	case string:
+		return c.UnmarshalText([]byte(v))
+	case []byte:
+		return c.UnmarshalText(v)
+	}
+	return fmt.Errorf("cannot scan %T into a UnitCode", src)
+}
+
+// Value implements driver.Valuer, storing the zero UnitCode as NULL
+func (c UnitCode) Value() (driver.Value, error) {
+	if c.IsZero() {
+		return nil, nil
+	}
+	return c.String(), nil
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseUnitCode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    UnitCode
+		wantErr bool
+	}{
+		{in: "20_1230", want: UnitCode{Shisya: "20", Danchi: "123", Shikibetu: "0"}},
+		{in: "40_12312", want: UnitCode{Shisya: "40", Danchi: "123", Shikibetu: "12"}},
+		{in: "20_123", wantErr: true},   // no shikibetu
+		{in: "_1230", wantErr: true},    // no shisya
+		{in: "20_12a0", wantErr: true},  // non-digit danchi
+		{in: "20_1230a", wantErr: true}, // non-digit shikibetu
+		{in: "201230", wantErr: true},   // no separator
+		{in: "20_1_230", wantErr: true},
+		{in: "２０_１２３０", wantErr: true}, // full-width digits
+		{in: "20_団地1230", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseUnitCode(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidUnitCode) {
+					t.Errorf("ParseUnitCode(%q) error = %v, want ErrInvalidUnitCode", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseUnitCode(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseUnitCode(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+			if got.String() != tt.in {
+				t.Errorf("String() = %q, want %q", got.String(), tt.in)
+			}
+		})
+	}
+}
+
+func FuzzParseUnitCode(f *testing.F) {
+	for _, seed := range []string{"20_1230", "40_12312", "20_123", "_1230", "20_12a0", "２０_１２３０", "", "_", "0_0000"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		code, err := ParseUnitCode(s)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidUnitCode) {
+				t.Errorf("ParseUnitCode(%q) error = %v, want ErrInvalidUnitCode", s, err)
+			}
+			return
+		}
+		if got := code.String(); got != s {
+			t.Errorf("ParseUnitCode(%q).String() = %q", s, got)
+		}
+		if err := code.Validate(); err != nil {
+			t.Errorf("ParseUnitCode(%q).Validate() = %v", s, err)
+		}
+	})
+}
+
+func TestUnitCodeScanAndValue(t *testing.T) {
+	var code UnitCode
+	if err := code.Scan([]byte("20_1230")); err != nil || code.String() != "20_1230" {
+		t.Errorf("Scan([]byte) = %v, code %q", err, code)
+	}
+	if err := code.Scan(nil); err != nil || !code.IsZero() {
+		t.Errorf("Scan(nil) = %v, code %+v, want the zero code", err, code)
+	}
+	if err := code.Scan("20_12a0"); !errors.Is(err, ErrInvalidUnitCode) {
+		t.Errorf("Scan(%q) error = %v, want ErrInvalidUnitCode", "20_12a0", err)
+	}
+
+	if v, err := (UnitCode{}).Value(); err != nil || v != nil {
+		t.Errorf("zero Value() = %v, %v, want NULL", v, err)
+	}
+	if v, err := (UnitCode{Shisya: "20", Danchi: "123", Shikibetu: "0"}).Value(); err != nil || v != "20_1230" {
+		t.Errorf("Value() = %v, %v, want 20_1230", v, err)
+	}
+}
+
+func TestUnitCodeJSON(t *testing.T) {
+	data, err := json.Marshal(struct{ Code UnitCode }{UnitCode{Shisya: "20", Danchi: "123", Shikibetu: "0"}})
+	if err != nil || string(data) != `{"Code":"20_1230"}` {
+		t.Errorf("Marshal() = %s, %v", data, err)
+	}
+
+	var v struct{ Code UnitCode }
+	if err := json.Unmarshal([]byte(`{"Code":"20_123"}`), &v); !errors.Is(err, ErrInvalidUnitCode) {
+		t.Errorf("Unmarshal(20_123) error = %v, want ErrInvalidUnitCode", err)
+	}
+}
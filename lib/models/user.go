@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// User is a LINE user who has added the bot as a friend
+type User struct {
+	LineUserID string
+	ReplyToken string
+	CreatedAt  time.Time
+}
+
+// Unit is a UR property that can be subscribed to. Rent and CommonFee are
+// the display strings from UR's listing, e.g. "85,400円～".
+type Unit struct {
+	ID        int
+	Name      string
+	Code      UnitCode
+	URL       string
+	Image     string
+	Rent      string
+	CommonFee string
+	// SKCID is the municipality the unit is listed in, or 0 if unknown
+	SKCID int
+	// Delisted is set once the unit disappears from UR's listings
+	Delisted bool
+}
+
+// UnitSummary is a unit with its number of active subscriptions
+type UnitSummary struct {
+	Unit
+	Subscribers int
+}
+
+// UnitCheck is the outcome of the most recent room check of a unit
+type UnitCheck struct {
+	CheckedAt time.Time
+	RoomCount int
+}
@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// areaListing is an area on a prefecture's area page with its skcs
+type areaListing struct {
+	Area models.Area
+	SKCs []models.SKC
+}
+
+// scriptPattern matches the script and style elements of a page, whose
+// contents would otherwise be read as markup
+var scriptPattern = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+
+// parseAreaPage reads the areas and skcs from a prefecture's area search
+// page. Each area and each of its skcs is a checkbox wrapped in a label:
+//
+//	<label><input type="checkbox" name="area" value="1"> 23区</label>
+//	<label><input type="checkbox" name="skcs" value="101"> 千代田区</label>
+//
+// An skc belongs to the area before it. The value of an skc checkbox is its
+// municipality code, which is also its UR id.
+func parseAreaPage(body []byte) ([]areaListing, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(scriptPattern.ReplaceAll(body, nil)))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var (
+		listings []areaListing
+		inLabel  bool
+		nested   int
+		label    strings.Builder
+		checkbox *xml.StartElement
+	)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse area page: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "label":
+				inLabel = true
+				nested = 0
+				label.Reset()
+				checkbox = nil
+				continue
+			case "input":
+				if inLabel && attr(t, "type") == "checkbox" {
+					input := t.Copy()
+					checkbox = &input
+				}
+			}
+			if inLabel {
+				nested++
+			}
+
+		case xml.CharData:
+			// Only the label's own text names the checkbox, not e.g. the
+			// count of danchi in a nested span
+			if inLabel && nested == 0 {
+				label.Write(t)
+			}
+
+		case xml.EndElement:
+			if strings.ToLower(t.Name.Local) != "label" {
+				if inLabel {
+					nested--
+				}
+				continue
+			}
+			if !inLabel {
+				continue
+			}
+			inLabel = false
+			if checkbox == nil {
+				continue
+			}
+
+			name := strings.Join(strings.Fields(label.String()), " ")
+			value := strings.TrimSpace(attr(*checkbox, "value"))
+			switch attr(*checkbox, "name") {
+			case "area":
+				listings = append(listings, areaListing{Area: models.Area{Code: value, Name: name}})
+			case "skcs":
+				if len(listings) == 0 {
+					return nil, fmt.Errorf("skc %s (%s) is listed before any area", value, name)
+				}
+				urID, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("skc %s has a non-numeric code %q", name, value)
+				}
+				last := &listings[len(listings)-1]
+				last.SKCs = append(last.SKCs, models.SKC{URID: urID, Code: value, Name: name})
+			}
+		}
+	}
+
+	if len(listings) == 0 {
+		return nil, fmt.Errorf("no areas found on the area page")
+	}
+	return listings, nil
+}
+
+// attr returns the value of an element's attribute, or "" if it has none
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,236 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/store"
+)
+
+// ChangeKind is what a crawl did to a unit
+type ChangeKind string
+
+const (
+	UnitInserted ChangeKind = "inserted"
+	UnitUpdated  ChangeKind = "updated"
+	UnitRemoved  ChangeKind = "removed"
+)
+
+// UnitChange is a unit a crawl inserted, updated or removed
+type UnitChange struct {
+	Code   models.UnitCode `json:"code"`
+	Name   string          `json:"name"`
+	Change ChangeKind      `json:"change"`
+}
+
+// Report is the result of a crawl
+type Report struct {
+	StartedAt   time.Time `json:"started_at"`
+	DurationMS  int64     `json:"duration_ms"`
+	Prefectures int       `json:"prefectures"`
+	Areas       int       `json:"areas"`
+	SKCs        int       `json:"skcs"`
+	Inserted    int       `json:"inserted"`
+	Updated     int       `json:"updated"`
+	Unchanged   int       `json:"unchanged"`
+	Removed     int       `json:"removed"`
+	// Errors lists the listings that could not be fetched and the units
+	// that could not be saved. The rest of the catalog is still synced.
+	Errors  []string     `json:"errors"`
+	Changes []UnitChange `json:"changes"`
+}
+
+// String renders the report totals for logs
+func (r *Report) String() string {
+	return fmt.Sprintf("%d prefectures, %d areas, %d skcs: %d inserted, %d updated, %d unchanged, %d removed, %d errors",
+		r.Prefectures, r.Areas, r.SKCs, r.Inserted, r.Updated, r.Unchanged, r.Removed, len(r.Errors))
+}
+
+func (r *Report) errorf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	r.Errors = append(r.Errors, msg)
+}
+
+func (r *Report) record(change ChangeKind, code models.UnitCode, name string) {
+	switch change {
+	case UnitInserted:
+		r.Inserted++
+	case UnitUpdated:
+		r.Updated++
+	case UnitRemoved:
+		r.Removed++
+	}
+	r.Changes = append(r.Changes, UnitChange{Code: code, Name: name, Change: change})
+}
+
+// Crawl walks the areas, skcs and danchi of the prefectures with the given
+// UR codes, or of every prefecture if none are given, and saves them to
+// stores. Running it twice against the same listings changes nothing.
+//
+// Units are matched by unit code. A unit that is no longer in its skc's
+// listing is marked as delisted rather than deleted, and only when that
+// listing was fetched successfully, so a failing request never removes
+// anything. An empty listing for an skc that still has units is reported as
+// an error rather than trusted, since UR answers some failures with an empty
+// list. The returned error is only set when the crawl could not start.
+func Crawl(ctx context.Context, stores store.Stores, source Source, prefectures []string) (*Report, error) {
+	report := &Report{StartedAt: time.Now(), Errors: []string{}, Changes: []UnitChange{}}
+	defer func() { report.DurationMS = time.Since(report.StartedAt).Milliseconds() }()
+
+	prefs, err := loadPrefectures(ctx, stores.Catalog, prefectures)
+	if err != nil {
+		return report, err
+	}
+
+	for _, pref := range prefs {
+		if err := ctx.Err(); err != nil {
+			report.errorf("Crawl stopped: %v", err)
+			break
+		}
+		report.Prefectures++
+
+		areas, err := source.Areas(ctx, pref)
+		if err != nil {
+			report.errorf("Error listing areas of %s: %v", pref.Name, err)
+			continue
+		}
+		for _, area := range areas {
+			crawlArea(ctx, stores, source, pref, area, report)
+		}
+	}
+
+	return report, nil
+}
+
+// loadPrefectures returns the prefectures with the given UR codes, failing
+// if any is unknown, or every prefecture if codes is empty
+func loadPrefectures(ctx context.Context, catalog store.CatalogStore, codes []string) ([]models.Prefecture, error) {
+	prefs, err := catalog.Prefectures(ctx, codes)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(prefs))
+	for _, pref := range prefs {
+		found[pref.URCode] = true
+	}
+	for _, code := range codes {
+		if !found[code] {
+			return nil, fmt.Errorf("unknown prefecture code %q", code)
+		}
+	}
+	return prefs, nil
+}
+
+func crawlArea(ctx context.Context, stores store.Stores, source Source, pref models.Prefecture, area models.Area, report *Report) {
+	areaID, err := stores.Catalog.SaveArea(ctx, pref.ID, area)
+	if err != nil {
+		report.errorf("Error saving area %s of %s: %v", area.Code, pref.Name, err)
+		return
+	}
+	report.Areas++
+
+	skcs, err := source.SKCs(ctx, pref, area)
+	if err != nil {
+		report.errorf("Error listing skcs of area %s: %v", area.Name, err)
+		return
+	}
+
+	for _, skc := range skcs {
+		skcID, err := stores.Catalog.SaveSKC(ctx, areaID, skc)
+		if err != nil {
+			report.errorf("Error saving skc %d of area %s: %v", skc.URID, area.Name, err)
+			continue
+		}
+		report.SKCs++
+
+		danchi, err := source.Danchi(ctx, pref, skc)
+		if err != nil {
+			report.errorf("Error listing danchi of %s: %v", skc.Name, err)
+			continue
+		}
+		syncUnits(ctx, stores.Units, skcID, danchi, report)
+	}
+}
+
+// syncUnits upserts the danchi listed for an skc and marks the skc's units
+// that are missing from the listing as delisted, unless none of the listing
+// could be used
+func syncUnits(ctx context.Context, units store.UnitStore, skcID int, danchi []Danchi, report *Report) {
+	listed := make([]models.UnitCode, 0, len(danchi))
+	seen := make(map[models.UnitCode]bool)
+	for _, d := range danchi {
+		code, err := d.UnitCode()
+		if err != nil {
+			report.errorf("Skipping danchi %q: %v", d.Name, err)
+			continue
+		}
+		if d.Name == "" {
+			report.errorf("Skipping danchi %s without a name", code)
+			continue
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		listed = append(listed, code)
+
+		inserted, changed, err := units.Upsert(ctx, models.Unit{
+			Name:      d.Name,
+			Code:      code,
+			URL:       d.URL,
+			Image:     d.Image,
+			Rent:      d.Rent,
+			CommonFee: d.CommonFee,
+			SKCID:     skcID,
+		})
+		switch {
+		case err != nil:
+			report.errorf("Error saving unit %s (%s): %v", d.Name, code, err)
+		case inserted:
+			report.record(UnitInserted, code, d.Name)
+		case changed:
+			report.record(UnitUpdated, code, d.Name)
+		default:
+			report.Unchanged++
+		}
+	}
+
+	if len(listed) == 0 {
+		keepUnlisted(ctx, units, skcID, report)
+		return
+	}
+
+	delisted, err := units.Delist(ctx, skcID, listed)
+	if err != nil {
+		report.errorf("Error removing delisted units of skc %d: %v", skcID, err)
+		return
+	}
+	for _, unit := range delisted {
+		report.record(UnitRemoved, unit.Code, unit.Name)
+	}
+}
+
+// keepUnlisted reports an error instead of delisting every unit of an skc
+// whose listing came back empty. An skc without units has nothing to lose.
+func keepUnlisted(ctx context.Context, units store.UnitStore, skcID int, report *Report) {
+	all, err := units.List(ctx)
+	if err != nil {
+		report.errorf("Error listing units of skc %d: %v", skcID, err)
+		return
+	}
+
+	kept := 0
+	for _, unit := range all {
+		if unit.SKCID == skcID {
+			kept++
+		}
+	}
+	if kept > 0 {
+		report.errorf("Listing of skc %d is empty, keeping its %d units", skcID, kept)
+	}
+}
@@ -0,0 +1,261 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/store"
+)
+
+// newFixtureServer serves the fixtures in testdata the way UR serves the
+// area page and the danchi list API. The fixtures follow UR's markup and
+// response fields, trimmed to two areas, three skcs and six danchi. Danchi
+// list pages without a fixture are empty.
+func newFixtureServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /chintai/kanto/tokyo/area/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		http.ServeFile(w, r, filepath.Join("testdata", "area_kanto_tokyo.html"))
+	})
+	mux.HandleFunc("POST /chintai/api/bukken/search/list_bukken/", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("block") != "kanto" {
+			http.Error(w, "unknown block", http.StatusBadRequest)
+			return
+		}
+		name := fmt.Sprintf("list_bukken_%s_%s_%s.json", r.FormValue("tdfk"), r.FormValue("skcs"), r.FormValue("pageIndex"))
+		body, err := os.ReadFile(filepath.Join("testdata", name))
+		if os.IsNotExist(err) {
+			body = []byte("[]")
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Write(body)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newFixtureSource(t *testing.T) *HTTPSource {
+	t.Helper()
+	server := newFixtureServer(t)
+	source, err := NewHTTPSource(Endpoints{
+		AreaPage:   server.URL + "/chintai/{block}/{prefecture}/area/",
+		DanchiList: server.URL + "/chintai/api/bukken/search/list_bukken/",
+	}, 0, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPSource() error = %v", err)
+	}
+	// Page through 千代田区's three danchi two at a time
+	source.pageSize = 2
+	return source
+}
+
+var tokyo = models.Prefecture{Name: "東京都", URCode: "13", Code: "TOKYO", Region: "KANTO"}
+
+func TestHTTPSourceParsesAreaPage(t *testing.T) {
+	source := newFixtureSource(t)
+	ctx := context.Background()
+
+	areas, err := source.Areas(ctx, tokyo)
+	if err != nil {
+		t.Fatalf("Areas() error = %v", err)
+	}
+	want := []models.Area{{Code: "1", Name: "23区"}, {Code: "2", Name: "市部"}}
+	if fmt.Sprint(areas) != fmt.Sprint(want) {
+		t.Fatalf("Areas() = %v, want %v", areas, want)
+	}
+
+	skcs, err := source.SKCs(ctx, tokyo, areas[0])
+	if err != nil {
+		t.Fatalf("SKCs() error = %v", err)
+	}
+	wantSKCs := []models.SKC{{URID: 101, Code: "101", Name: "千代田区"}, {URID: 113, Code: "113", Name: "文京区"}}
+	if fmt.Sprint(skcs) != fmt.Sprint(wantSKCs) {
+		t.Errorf("SKCs() = %v, want %v", skcs, wantSKCs)
+	}
+
+	danchi, err := source.Danchi(ctx, tokyo, skcs[0])
+	if err != nil {
+		t.Fatalf("Danchi() error = %v", err)
+	}
+	if len(danchi) != 3 {
+		t.Fatalf("Danchi() = %d danchi, want 3 across two pages", len(danchi))
+	}
+	first := danchi[0]
+	if first.Code != "20_2010" || first.Name != "神田須田町" || first.URL != "/chintai/kanto/tokyo/20_2010.html" ||
+		first.Rent != "158,900円～215,300円" || first.CommonFee != "4,200円" {
+		t.Errorf("Danchi()[0] = %+v", first)
+	}
+}
+
+func TestParseAreaPageRejectsPageWithoutAreas(t *testing.T) {
+	if _, err := parseAreaPage([]byte(`<html><body><p>ただいまメンテナンス中です</p></body></html>`)); err == nil {
+		t.Error("parseAreaPage() error = nil, want an error for a page without areas")
+	}
+}
+
+func TestCrawlIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	memory := store.NewMemory()
+	stores := memory.Stores()
+	pref := memory.AddPrefecture(tokyo)
+
+	// 千代田区 already lists a danchi with an old rent and one UR has removed
+	areaID, err := stores.Catalog.SaveArea(ctx, pref.ID, models.Area{Code: "1", Name: "23区"})
+	if err != nil {
+		t.Fatalf("SaveArea() error = %v", err)
+	}
+	skcID, err := stores.Catalog.SaveSKC(ctx, areaID, models.SKC{URID: 101, Code: "101", Name: "千代田区"})
+	if err != nil {
+		t.Fatalf("SaveSKC() error = %v", err)
+	}
+	for _, unit := range []models.Unit{
+		{Name: "神田須田町", Code: models.UnitCode{Shisya: "20", Danchi: "201", Shikibetu: "0"}, SKCID: skcID,
+			URL: "/chintai/kanto/tokyo/20_2010.html", Image: "/chintai/img_photo/20/20_201/20_201_0_main.jpg",
+			Rent: "155,000円～", CommonFee: "4,200円"},
+		{Name: "一番町", Code: models.UnitCode{Shisya: "20", Danchi: "299", Shikibetu: "0"}, SKCID: skcID},
+	} {
+		if _, _, err := stores.Units.Upsert(ctx, unit); err != nil {
+			t.Fatalf("Upsert() error = %v", err)
+		}
+	}
+
+	source := newFixtureSource(t)
+	report, err := Crawl(ctx, stores, source, []string{"13"})
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+
+	if report.Prefectures != 1 || report.Areas != 2 || report.SKCs != 3 {
+		t.Errorf("first crawl: %d prefectures, %d areas, %d skcs, want 1, 2 and 3", report.Prefectures, report.Areas, report.SKCs)
+	}
+	if report.Inserted != 5 || report.Updated != 1 || report.Removed != 1 || report.Unchanged != 0 {
+		t.Errorf("first crawl: %s, want 5 inserted, 1 updated, 1 removed", report)
+	}
+	// めじろ台 is listed without a shikibetu
+	if len(report.Errors) != 1 {
+		t.Errorf("first crawl errors = %q, want one for the invalid unit code", report.Errors)
+	}
+	changes := make(map[string]ChangeKind)
+	for _, change := range report.Changes {
+		changes[change.Code.String()] = change.Change
+	}
+	if changes["20_2010"] != UnitUpdated || changes["20_2990"] != UnitRemoved || changes["30_5010"] != UnitInserted {
+		t.Errorf("first crawl changes = %v", changes)
+	}
+
+	listed, err := stores.Units.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var names []string
+	for _, unit := range listed {
+		names = append(names, unit.Name)
+	}
+	sort.Strings(names)
+	if want := "[九段下ガーデン 八王子みなみ野 小石川五丁目 本駒込二丁目 神田須田町 飯田橋セントラルプラザ]"; fmt.Sprint(names) != want {
+		t.Errorf("listed units = %v, want %s", names, want)
+	}
+
+	report, err = Crawl(ctx, stores, source, []string{"13"})
+	if err != nil {
+		t.Fatalf("second Crawl() error = %v", err)
+	}
+	if report.Inserted != 0 || report.Updated != 0 || report.Removed != 0 || len(report.Changes) != 0 || report.Unchanged != 6 {
+		t.Errorf("second crawl: %s, changes %v, want 6 unchanged and nothing else", report, report.Changes)
+	}
+}
+
+func TestCrawlRejectsUnknownPrefecture(t *testing.T) {
+	memory := store.NewMemory()
+	memory.AddPrefecture(tokyo)
+
+	if _, err := Crawl(context.Background(), memory.Stores(), NewFakeSource(), []string{"13", "99"}); err == nil {
+		t.Error("Crawl() error = nil, want an error for prefecture 99")
+	}
+}
+
+func TestCrawlKeepsUnitsWhenListingFails(t *testing.T) {
+	ctx := context.Background()
+	memory := store.NewMemory()
+	stores := memory.Stores()
+	memory.AddPrefecture(tokyo)
+
+	source := NewFakeSource()
+	chiyoda := models.SKC{URID: 101, Code: "101", Name: "千代田区"}
+	source.SetAreas("13", models.Area{Code: "1", Name: "23区"})
+	source.SetSKCs("13", "1", chiyoda)
+	source.SetDanchi("13", 101, Danchi{Code: "20_2010", Name: "神田須田町"})
+	if _, err := Crawl(ctx, stores, source, nil); err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+
+	source.SetDanchiError("13", 101, fmt.Errorf("connection reset"))
+	report, err := Crawl(ctx, stores, source, nil)
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	if report.Removed != 0 || len(report.Errors) != 1 {
+		t.Errorf("crawl with a failed listing: %s, want nothing removed and one error", report)
+	}
+	if units, _ := stores.Units.List(ctx); len(units) != 1 {
+		t.Errorf("listed units = %v, want 神田須田町 kept", units)
+	}
+}
+
+func TestCrawlKeepsUnitsWhenListingIsEmpty(t *testing.T) {
+	ctx := context.Background()
+	memory := store.NewMemory()
+	stores := memory.Stores()
+	memory.AddPrefecture(tokyo)
+
+	source := NewFakeSource()
+	chiyoda := models.SKC{URID: 101, Code: "101", Name: "千代田区"}
+	bunkyo := models.SKC{URID: 105, Code: "105", Name: "文京区"}
+	source.SetAreas("13", models.Area{Code: "1", Name: "23区"})
+	source.SetSKCs("13", "1", chiyoda, bunkyo)
+	source.SetDanchi("13", 101, Danchi{Code: "20_2010", Name: "神田須田町"}, Danchi{Code: "20_2020", Name: "飯田橋セントラルプラザ"})
+	report, err := Crawl(ctx, stores, source, nil)
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	// 文京区 lists nothing and has no units to lose
+	if report.Inserted != 2 || len(report.Errors) != 0 {
+		t.Fatalf("first crawl: %s, errors %q, want 2 inserted and no errors", report, report.Errors)
+	}
+
+	// UR answers null for 千代田区, as it does for a page past the last
+	source.SetDanchi("13", 101)
+	report, err = Crawl(ctx, stores, source, nil)
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	if report.Removed != 0 || len(report.Errors) != 1 {
+		t.Errorf("crawl with an empty listing: %s, errors %q, want nothing removed and one error", report, report.Errors)
+	}
+	if units, _ := stores.Units.List(ctx); len(units) != 2 {
+		t.Errorf("listed units = %v, want both kept", units)
+	}
+
+	// A listing with any danchi still delists the ones missing from it
+	source.SetDanchi("13", 101, Danchi{Code: "20_2010", Name: "神田須田町"})
+	report, err = Crawl(ctx, stores, source, nil)
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	if report.Removed != 1 || len(report.Errors) != 0 {
+		t.Errorf("crawl with a shorter listing: %s, errors %q, want 1 removed", report, report.Errors)
+	}
+}
@@ -0,0 +1,92 @@
+package catalog
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// FakeSource is an in-memory Source for tests and local runs. Listings that
+// were never set are empty.
+type FakeSource struct {
+	mu     sync.Mutex
+	areas  map[string][]models.Area
+	skcs   map[string][]models.SKC
+	danchi map[string][]Danchi
+	errors map[string]error
+}
+
+// NewFakeSource creates an empty FakeSource
+func NewFakeSource() *FakeSource {
+	return &FakeSource{
+		areas:  make(map[string][]models.Area),
+		skcs:   make(map[string][]models.SKC),
+		danchi: make(map[string][]Danchi),
+		errors: make(map[string]error),
+	}
+}
+
+// SetAreas sets the areas listed for the prefecture with a UR code
+func (f *FakeSource) SetAreas(prefecture string, areas ...models.Area) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.areas[prefecture] = areas
+}
+
+// SetSKCs sets the skcs listed for an area of a prefecture
+func (f *FakeSource) SetSKCs(prefecture, area string, skcs ...models.SKC) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.skcs[prefecture+"/"+area] = skcs
+}
+
+// SetDanchi sets the danchi listed for an skc of a prefecture
+func (f *FakeSource) SetDanchi(prefecture string, skc int, danchi ...Danchi) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.danchi[prefecture+"/"+strconv.Itoa(skc)] = danchi
+}
+
+// SetDanchiError makes listing the danchi of an skc fail with err
+func (f *FakeSource) SetDanchiError(prefecture string, skc int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[prefecture+"/"+strconv.Itoa(skc)] = err
+}
+
+// Areas implements Source
+func (f *FakeSource) Areas(ctx context.Context, pref models.Prefecture) ([]models.Area, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.areas[pref.URCode], nil
+}
+
+// SKCs implements Source
+func (f *FakeSource) SKCs(ctx context.Context, pref models.Prefecture, area models.Area) ([]models.SKC, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.skcs[pref.URCode+"/"+area.Code], nil
+}
+
+// Danchi implements Source
+func (f *FakeSource) Danchi(ctx context.Context, pref models.Prefecture, skc models.SKC) ([]Danchi, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := pref.URCode + "/" + strconv.Itoa(skc.URID)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err, ok := f.errors[key]; ok {
+		return nil, err
+	}
+	return f.danchi[key], nil
+}
@@ -0,0 +1,262 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/ratelimit"
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+// Endpoints are the UR pages a crawl reads
+type Endpoints struct {
+	// AreaPage is the HTML search page listing a prefecture's areas with
+	// their municipalities as checkboxes. {block} and {prefecture} are
+	// replaced with the prefecture's UR block and lower-case romanized name,
+	// e.g. kanto and tokyo.
+	AreaPage string
+	// DanchiList is the JSON API the search page posts a municipality to for
+	// its danchi, a page at a time
+	DanchiList string
+}
+
+// DefaultEndpoints are UR's own
+var DefaultEndpoints = Endpoints{
+	AreaPage:   "https://www.ur-net.go.jp/chintai/{block}/{prefecture}/area/",
+	DanchiList: "https://chintai.r6.ur-net.go.jp/chintai/api/bukken/search/list_bukken/",
+}
+
+// Validate reports a missing endpoint
+func (e Endpoints) Validate() error {
+	switch {
+	case e.AreaPage == "":
+		return fmt.Errorf("area page URL is not set")
+	case e.DanchiList == "":
+		return fmt.Errorf("danchi list URL is not set")
+	}
+	return nil
+}
+
+const (
+	// danchiPageSize is how many danchi are requested per page
+	danchiPageSize = 50
+	// maxDanchiPages bounds the pages read for one municipality in case the
+	// API keeps returning full pages
+	maxDanchiPages = 100
+)
+
+// HTTPSource reads the catalog from UR's search pages
+type HTTPSource struct {
+	endpoints  Endpoints
+	limiter    *ratelimit.TokenBucket
+	httpClient *http.Client
+	pageSize   int
+
+	// areas caches each prefecture's parsed area page, which lists both its
+	// areas and their skcs
+	mu    sync.Mutex
+	areas map[string][]areaListing
+}
+
+// NewHTTPSource creates a source reading endpoints. Requests wait for
+// limiter if it is not nil. A zero timeout falls back to
+// urclient.DefaultTimeout.
+func NewHTTPSource(endpoints Endpoints, timeout time.Duration, limiter *ratelimit.TokenBucket) (*HTTPSource, error) {
+	if err := endpoints.Validate(); err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = urclient.DefaultTimeout
+	}
+
+	return &HTTPSource{
+		endpoints:  endpoints,
+		limiter:    limiter,
+		httpClient: &http.Client{Timeout: timeout},
+		pageSize:   danchiPageSize,
+		areas:      make(map[string][]areaListing),
+	}, nil
+}
+
+// Areas implements Source. It reads the prefecture's area page.
+func (s *HTTPSource) Areas(ctx context.Context, pref models.Prefecture) ([]models.Area, error) {
+	listings, err := s.fetchAreaPage(ctx, pref)
+	if err != nil {
+		return nil, err
+	}
+
+	areas := make([]models.Area, 0, len(listings))
+	for _, listing := range listings {
+		areas = append(areas, listing.Area)
+	}
+	return areas, nil
+}
+
+// SKCs implements Source. It reads the area page fetched by Areas, or
+// fetches it if Areas has not been called for the prefecture.
+func (s *HTTPSource) SKCs(ctx context.Context, pref models.Prefecture, area models.Area) ([]models.SKC, error) {
+	s.mu.Lock()
+	listings, ok := s.areas[pref.URCode]
+	s.mu.Unlock()
+
+	if !ok {
+		var err error
+		if listings, err = s.fetchAreaPage(ctx, pref); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, listing := range listings {
+		if listing.Area.Code == area.Code {
+			return listing.SKCs, nil
+		}
+	}
+	return nil, fmt.Errorf("area %s is not on the area page of %s", area.Code, pref.Name)
+}
+
+// Danchi implements Source. It pages through the danchi list API.
+func (s *HTTPSource) Danchi(ctx context.Context, pref models.Prefecture, skc models.SKC) ([]Danchi, error) {
+	var danchi []Danchi
+	for page := 0; page < maxDanchiPages; page++ {
+		form := url.Values{}
+		form.Set("block", block(pref))
+		form.Set("tdfk", pref.URCode)
+		form.Set("skcs", skc.Code)
+		form.Set("orderByField", "0")
+		form.Set("pageSize", strconv.Itoa(s.pageSize))
+		form.Set("pageIndex", strconv.Itoa(page))
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoints.DanchiList, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+		req.Header.Set("Accept", "application/json, text/javascript, */*; q=0.01")
+
+		body, contentType, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		items, err := parseDanchiList(body)
+		if err != nil {
+			return nil, &urclient.DecodeError{ContentType: contentType, Body: string(body), Err: err}
+		}
+
+		danchi = append(danchi, items...)
+		if len(items) < s.pageSize {
+			return danchi, nil
+		}
+	}
+	return nil, fmt.Errorf("danchi list of %s has more than %d pages", skc.Name, maxDanchiPages)
+}
+
+// fetchAreaPage fetches, parses and caches the prefecture's area page
+func (s *HTTPSource) fetchAreaPage(ctx context.Context, pref models.Prefecture) ([]areaListing, error) {
+	pageURL := strings.NewReplacer(
+		"{block}", url.PathEscape(block(pref)),
+		"{prefecture}", url.PathEscape(strings.ToLower(pref.Code)),
+	).Replace(s.endpoints.AreaPage)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	body, contentType, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	listings, err := parseAreaPage(body)
+	if err != nil {
+		return nil, &urclient.DecodeError{ContentType: contentType, Body: string(body), Err: err}
+	}
+
+	s.mu.Lock()
+	s.areas[pref.URCode] = listings
+	s.mu.Unlock()
+	return listings, nil
+}
+
+// do waits for the limiter, sends req and returns the body of a 200
+// response with its content type
+func (s *HTTPSource) do(req *http.Request) ([]byte, string, error) {
+	if s.limiter != nil {
+		if err := s.limiter.Wait(req.Context()); err != nil {
+			return nil, "", err
+		}
+	}
+
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Referer", "https://www.ur-net.go.jp/")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &urclient.StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// block returns the UR site block a prefecture's pages are under. UR splits
+// the Hokkaido/Tohoku region into two blocks.
+func block(pref models.Prefecture) string {
+	if pref.Region == "HOKKAIDO_TOHOKU" {
+		if pref.Code == "HOKKAIDO" {
+			return "hokkaido"
+		}
+		return "tohoku"
+	}
+	return strings.ToLower(pref.Region)
+}
+
+// bukken is a danchi in the list API's response. Its id is the unit code;
+// the shisya, danchi and shikibetu fields repeat its parts.
+type bukken struct {
+	ID        string `json:"id"`
+	Name      string `json:"danchiNm"`
+	URL       string `json:"bukkenUrl"`
+	Image     string `json:"image"`
+	Rent      string `json:"rent"`
+	CommonFee string `json:"commonfee"`
+}
+
+// parseDanchiList decodes a page of the danchi list API, which is a JSON
+// array, or null past the last page
+func parseDanchiList(body []byte) ([]Danchi, error) {
+	var items []bukken
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, err
+	}
+
+	danchi := make([]Danchi, 0, len(items))
+	for _, item := range items {
+		danchi = append(danchi, Danchi{
+			Code:      item.ID,
+			Name:      strings.TrimSpace(item.Name),
+			URL:       item.URL,
+			Image:     item.Image,
+			Rent:      item.Rent,
+			CommonFee: item.CommonFee,
+		})
+	}
+	return danchi, nil
+}
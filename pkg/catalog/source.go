@@ -0,0 +1,33 @@
+// Package catalog crawls UR's property listings and keeps the areas, skcs
+// and units tables in sync with them.
+package catalog
+
+import (
+	"context"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// Danchi is a property listed in a municipality. Rent and CommonFee are the
+// display strings shown on the listing, e.g. "85,400円～".
+type Danchi struct {
+	// Code is the unit code as listed, e.g. "20_1230"
+	Code      string
+	Name      string
+	URL       string
+	Image     string
+	Rent      string
+	CommonFee string
+}
+
+// UnitCode parses the danchi's unit code
+func (d Danchi) UnitCode() (models.UnitCode, error) {
+	return models.ParseUnitCode(d.Code)
+}
+
+// Source lists the UR catalog one level at a time
+type Source interface {
+	Areas(ctx context.Context, pref models.Prefecture) ([]models.Area, error)
+	SKCs(ctx context.Context, pref models.Prefecture, area models.Area) ([]models.SKC, error)
+	Danchi(ctx context.Context, pref models.Prefecture, skc models.SKC) ([]Danchi, error)
+}
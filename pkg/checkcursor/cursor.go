@@ -0,0 +1,127 @@
+// Package checkcursor splits the room check over shards and remembers how
+// far each shard got, so successive runs resume where the last one stopped.
+package checkcursor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Shard is one deterministic slice of the units table. A unit belongs to the
+// shard whose Index equals its id modulo Count.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// All is the single shard covering every unit
+var All = Shard{Index: 0, Count: 1}
+
+// ParseShard parses the shard and of query parameters. Both empty means All.
+func ParseShard(index, count string) (Shard, error) {
+	if index == "" && count == "" {
+		return All, nil
+	}
+
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard %q", index)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard count %q", count)
+	}
+	if n < 1 || i < 0 || i >= n {
+		return Shard{}, fmt.Errorf("shard %d out of range for %d shards", i, n)
+	}
+
+	return Shard{Index: i, Count: n}, nil
+}
+
+// String renders the shard as index/count
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// lockClass is the first key of the advisory locks held by shard runs; the
+// second is a hash of the shard
+const lockClass = 7_252_015
+
+// ErrLocked is returned by Lock when another run holds the shard
+var ErrLocked = errors.New("shard is already being checked")
+
+// Lock takes the shard's advisory lock so that runs of the same shard never
+// overlap, returning ErrLocked straight away if another run holds it. The
+// lock lives on a dedicated connection until the returned func is called.
+func Lock(ctx context.Context, db *sql.DB, shard Shard) (release func(), err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a connection: %w", err)
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2))", lockClass, shard.String()).Scan(&locked)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to lock shard %s: %w", shard, err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, ErrLocked
+	}
+
+	return func() {
+		conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1, hashtext($2))", lockClass, shard.String())
+		conn.Close()
+	}, nil
+}
+
+// Load returns the id of the last unit the shard finished, or 0 if the
+// shard is at the start of a cycle
+func Load(ctx context.Context, db *sql.DB, shard Shard) (int, error) {
+	var lastUnitID int
+	err := db.QueryRowContext(ctx, `
+		SELECT last_unit_id FROM room_check_cursors
+		WHERE shard_index = $1 AND shard_count = $2`, shard.Index, shard.Count).Scan(&lastUnitID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to load check cursor: %w", err)
+	}
+	return lastUnitID, nil
+}
+
+// Advance records that the shard has finished every unit up to and including
+// lastUnitID
+func Advance(ctx context.Context, db *sql.DB, shard Shard, lastUnitID int) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO room_check_cursors (shard_index, shard_count, last_unit_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (shard_index, shard_count) DO UPDATE SET last_unit_id = EXCLUDED.last_unit_id`,
+		shard.Index, shard.Count, lastUnitID)
+	if err != nil {
+		return fmt.Errorf("failed to save check cursor: %w", err)
+	}
+	return nil
+}
+
+// Complete records that the shard has finished every unit, so the next run
+// starts a new cycle from the beginning
+func Complete(ctx context.Context, db *sql.DB, shard Shard) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO room_check_cursors (shard_index, shard_count, last_unit_id, cycles_completed)
+		VALUES ($1, $2, 0, 1)
+		ON CONFLICT (shard_index, shard_count) DO UPDATE SET
+			last_unit_id = 0,
+			cycles_completed = room_check_cursors.cycles_completed + 1,
+			cycle_started_at = CURRENT_TIMESTAMP`,
+		shard.Index, shard.Count)
+	if err != nil {
+		return fmt.Errorf("failed to save check cursor: %w", err)
+	}
+	return nil
+}
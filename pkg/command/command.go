@@ -0,0 +1,67 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// Command is a parsed chat command
+type Command interface {
+	command()
+}
+
+// Help asks for the usage message
+type Help struct{}
+
+// List asks for the user's current subscriptions
+type List struct{}
+
+// Status asks for the last check time and result of each subscription
+type Status struct{}
+
+// UnsubscribeAll cancels every subscription of the user
+type UnsubscribeAll struct{}
+
+// Unsubscribe cancels the subscription to a single property ("-Property Name")
+type Unsubscribe struct {
+	UnitName string
+}
+
+// Subscribe subscribes to a property with optional room types, filters and
+// mode ("Property Name:3LDK&4LDK:rent<=150000:keep")
+type Subscribe struct {
+	UnitName  string
+	RoomTypes []string
+	Filters   models.SubscriptionFilters
+	Mode      models.SubscriptionMode
+}
+
+func (Help) command()           {}
+func (List) command()           {}
+func (Status) command()         {}
+func (UnsubscribeAll) command() {}
+func (Unsubscribe) command()    {}
+func (Subscribe) command()      {}
+
+// String renders the command back in chat syntax, so it can be offered as a
+// quick reply once the property name has been corrected
+func (c Unsubscribe) String() string {
+	return "-" + c.UnitName
+}
+
+// String renders the command back in chat syntax, so it can be offered as a
+// quick reply once the property name has been corrected
+func (c Subscribe) String() string {
+	segments := []string{c.UnitName}
+	if len(c.RoomTypes) > 0 {
+		segments = append(segments, strings.Join(c.RoomTypes, "&"))
+	}
+	if !c.Filters.IsEmpty() {
+		segments = append(segments, strings.Split(c.Filters.String(), ", ")...)
+	}
+	if c.Mode == models.SubscriptionModePersistent {
+		segments = append(segments, persistentKeywords[0])
+	}
+	return strings.Join(segments, ":")
+}
@@ -0,0 +1,181 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// SyntaxError is returned when a message cannot be parsed as a command
+type SyntaxError struct {
+	Input  string
+	Reason string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid command %q: %s", e.Input, e.Reason)
+}
+
+// keywords maps the words accepted for each fixed command, compared case-insensitively
+var keywords = []struct {
+	words   []string
+	command Command
+}{
+	{[]string{"help", "?", "ヘルプ", "使い方"}, Help{}},
+	{[]string{"list", "一覧", "リスト"}, List{}},
+	{[]string{"status", "状況", "ステータス"}, Status{}},
+	{[]string{"unsubscribe all", "全解除", "すべて解除"}, UnsubscribeAll{}},
+}
+
+// persistentKeywords are the suffixes that keep a subscription active after
+// a notification, e.g. "恵比寿ビュータワー:3LDK:keep"
+var persistentKeywords = []string{"keep", "継続"}
+
+// filterPattern matches filter segments such as "rent<=150000", "rent<=15万",
+// "area>=60" or "floor>=3"
+var filterPattern = regexp.MustCompile(`^(?i:(rent|area|floor))\s*(<=|>=|=)\s*([0-9][0-9,]*(?:\.[0-9]+)?)\s*(万)?$`)
+
+// filterPrefix matches anything that looks like an attempted filter, so that
+// "rent<150000" is reported as an error instead of becoming a room type
+var filterPrefix = regexp.MustCompile(`^(?i:rent|area|floor)\s*[<>=]`)
+
+// Parse parses a chat message into a Command. Anything that is not a
+// keyword is treated as a subscribe ("Name[:options]") or an unsubscribe
+// ("-Name") command.
+func Parse(text string) (Command, error) {
+	normalized := strings.Join(strings.Fields(text), " ")
+	if normalized == "" {
+		return nil, &SyntaxError{Input: text, Reason: "empty message"}
+	}
+	for _, keyword := range keywords {
+		for _, word := range keyword.words {
+			if strings.EqualFold(normalized, word) {
+				return keyword.command, nil
+			}
+		}
+	}
+
+	tokens := Tokenize(text)
+	if tokens[0].Kind == TokenDash {
+		return parseUnsubscribe(text, tokens[1:])
+	}
+	return parseSubscribe(text, tokens)
+}
+
+// parseUnsubscribe parses the tokens following a leading dash
+func parseUnsubscribe(input string, tokens []Token) (Command, error) {
+	if len(tokens) != 1 || tokens[0].Value == "" {
+		return nil, &SyntaxError{Input: input, Reason: "expected a single property name after \"-\""}
+	}
+	return Unsubscribe{UnitName: tokens[0].Value}, nil
+}
+
+// parseSubscribe parses a property name followed by optional segments in any
+// order: room types ("3LDK&4LDK"), filters ("rent<=150000") and "keep"
+func parseSubscribe(input string, tokens []Token) (Command, error) {
+	cmd := Subscribe{
+		UnitName: tokens[0].Value,
+		Mode:     models.SubscriptionModeOneShot,
+	}
+	if cmd.UnitName == "" {
+		return nil, &SyntaxError{Input: input, Reason: "missing property name"}
+	}
+
+	for _, token := range tokens[1:] {
+		if token.Kind != TokenText {
+			continue
+		}
+		segment := token.Value
+
+		if isPersistentKeyword(segment) {
+			if cmd.Mode == models.SubscriptionModePersistent {
+				return nil, &SyntaxError{Input: input, Reason: "duplicate \"keep\""}
+			}
+			cmd.Mode = models.SubscriptionModePersistent
+			continue
+		}
+
+		isFilter, err := parseFilter(segment, &cmd.Filters)
+		if err != nil {
+			return nil, &SyntaxError{Input: input, Reason: err.Error()}
+		}
+		if isFilter {
+			continue
+		}
+
+		if segment == "" {
+			return nil, &SyntaxError{Input: input, Reason: "empty segment"}
+		}
+		if cmd.RoomTypes != nil {
+			return nil, &SyntaxError{Input: input, Reason: "room types given more than once"}
+		}
+		for _, roomType := range strings.FieldsFunc(segment, func(r rune) bool { return r == '&' || r == '＆' }) {
+			cmd.RoomTypes = append(cmd.RoomTypes, strings.TrimSpace(roomType))
+		}
+	}
+
+	if err := models.ValidateRoomTypes(cmd.RoomTypes); err != nil {
+		return nil, &SyntaxError{Input: input, Reason: err.Error()}
+	}
+	if err := cmd.Filters.Validate(); err != nil {
+		return nil, &SyntaxError{Input: input, Reason: err.Error()}
+	}
+	return cmd, nil
+}
+
+// isPersistentKeyword reports whether a segment selects persistent mode
+func isPersistentKeyword(segment string) bool {
+	for _, keyword := range persistentKeywords {
+		if strings.EqualFold(segment, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFilter applies a filter segment such as "rent<=150000" to filters.
+// It returns false if the segment is not a filter at all.
+func parseFilter(segment string, filters *models.SubscriptionFilters) (bool, error) {
+	if !filterPrefix.MatchString(segment) {
+		return false, nil
+	}
+	match := filterPattern.FindStringSubmatch(segment)
+	if match == nil {
+		return true, fmt.Errorf("unsupported filter: %s", segment)
+	}
+
+	field, op, unit := strings.ToLower(match[1]), match[2], match[4]
+	value, err := strconv.ParseFloat(strings.ReplaceAll(match[3], ",", ""), 64)
+	if err != nil {
+		return true, fmt.Errorf("invalid filter value: %s", segment)
+	}
+	if unit != "" && field != "rent" {
+		return true, fmt.Errorf("unit 万 is only valid for rent: %s", segment)
+	}
+
+	switch {
+	case field == "rent" && op == "<=":
+		if unit == "万" {
+			value *= 10000
+		}
+		rent := int(value)
+		filters.MaxRent = &rent
+	case field == "area" && op == ">=":
+		filters.MinFloorArea = &value
+	case field == "floor" && value == float64(int(value)):
+		floor := int(value)
+		if op == "<=" || op == "=" {
+			filters.MaxFloor = &floor
+		}
+		if op == ">=" || op == "=" {
+			filters.MinFloor = &floor
+		}
+	default:
+		return true, fmt.Errorf("unsupported filter: %s", segment)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,176 @@
+package command
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+func intPtr(n int) *int { return &n }
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestParseKeywords(t *testing.T) {
+	tests := []struct {
+		text string
+		want Command
+	}{
+		{"help", Help{}},
+		{"HELP", Help{}},
+		{"?", Help{}},
+		{"ヘルプ", Help{}},
+		{"使い方", Help{}},
+		{"list", List{}},
+		{"一覧", List{}},
+		{"リスト", List{}},
+		{"status", Status{}},
+		{"状況", Status{}},
+		{"ステータス", Status{}},
+		{"unsubscribe all", UnsubscribeAll{}},
+		{"  Unsubscribe   all ", UnsubscribeAll{}},
+		{"全解除", UnsubscribeAll{}},
+		{"すべて解除", UnsubscribeAll{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnsubscribe(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"-恵比寿ビュータワー", "恵比寿ビュータワー"},
+		{"  - 恵比寿ビュータワー  ", "恵比寿ビュータワー"},
+		{"－恵比寿ビュータワー", "恵比寿ビュータワー"},
+		{"- 光が丘パークタウン 大通り中央", "光が丘パークタウン 大通り中央"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.text, err)
+			}
+			if want := (Unsubscribe{UnitName: tt.want}); got != want {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.text, got, want)
+			}
+		})
+	}
+}
+
+func TestParseSubscribe(t *testing.T) {
+	oneShot, persistent := models.SubscriptionModeOneShot, models.SubscriptionModePersistent
+	tests := []struct {
+		name string
+		text string
+		want Subscribe
+	}{
+		{"name only", "恵比寿ビュータワー", Subscribe{UnitName: "恵比寿ビュータワー", Mode: oneShot}},
+		{"room types", "恵比寿ビュータワー:3LDK&4LDK",
+			Subscribe{UnitName: "恵比寿ビュータワー", RoomTypes: []string{"3LDK", "4LDK"}, Mode: oneShot}},
+		{"full-width separators", "恵比寿ビュータワー：3LDK＆4LDK",
+			Subscribe{UnitName: "恵比寿ビュータワー", RoomTypes: []string{"3LDK", "4LDK"}, Mode: oneShot}},
+		{"spaces around separators", " 恵比寿ビュータワー : 3LDK & 4LDK ",
+			Subscribe{UnitName: "恵比寿ビュータワー", RoomTypes: []string{"3LDK", "4LDK"}, Mode: oneShot}},
+		// Room types are matched against UR's own labels, so any label is kept
+		{"unknown room type", "恵比寿ビュータワー:5SLDK&ワンルーム",
+			Subscribe{UnitName: "恵比寿ビュータワー", RoomTypes: []string{"5SLDK", "ワンルーム"}, Mode: oneShot}},
+		{"rent in yen", "恵比寿ビュータワー:rent<=150000",
+			Subscribe{UnitName: "恵比寿ビュータワー", Filters: models.SubscriptionFilters{MaxRent: intPtr(150000)}, Mode: oneShot}},
+		{"rent with commas", "恵比寿ビュータワー:rent<=150,000",
+			Subscribe{UnitName: "恵比寿ビュータワー", Filters: models.SubscriptionFilters{MaxRent: intPtr(150000)}, Mode: oneShot}},
+		{"rent in 万", "恵比寿ビュータワー:rent<=15万",
+			Subscribe{UnitName: "恵比寿ビュータワー", Filters: models.SubscriptionFilters{MaxRent: intPtr(150000)}, Mode: oneShot}},
+		{"rent in fractional 万", "恵比寿ビュータワー:RENT <= 12.5万",
+			Subscribe{UnitName: "恵比寿ビュータワー", Filters: models.SubscriptionFilters{MaxRent: intPtr(125000)}, Mode: oneShot}},
+		{"area", "恵比寿ビュータワー:area>=60.5",
+			Subscribe{UnitName: "恵比寿ビュータワー", Filters: models.SubscriptionFilters{MinFloorArea: floatPtr(60.5)}, Mode: oneShot}},
+		{"floor range", "恵比寿ビュータワー:floor>=3:floor<=10",
+			Subscribe{UnitName: "恵比寿ビュータワー", Filters: models.SubscriptionFilters{MinFloor: intPtr(3), MaxFloor: intPtr(10)}, Mode: oneShot}},
+		{"single floor", "恵比寿ビュータワー:floor=5",
+			Subscribe{UnitName: "恵比寿ビュータワー", Filters: models.SubscriptionFilters{MinFloor: intPtr(5), MaxFloor: intPtr(5)}, Mode: oneShot}},
+		{"keep", "恵比寿ビュータワー:keep", Subscribe{UnitName: "恵比寿ビュータワー", Mode: persistent}},
+		{"継続", "恵比寿ビュータワー:継続", Subscribe{UnitName: "恵比寿ビュータワー", Mode: persistent}},
+		{"everything in any order", "恵比寿ビュータワー:KEEP:rent<=15万:3LDK:area>=60:floor>=3",
+			Subscribe{
+				UnitName:  "恵比寿ビュータワー",
+				RoomTypes: []string{"3LDK"},
+				Filters:   models.SubscriptionFilters{MaxRent: intPtr(150000), MinFloorArea: floatPtr(60), MinFloor: intPtr(3)},
+				Mode:      persistent,
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.text, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsInvalidCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", "  "},
+		{"dash only", "-"},
+		{"dash with options", "-恵比寿ビュータワー:3LDK"},
+		{"missing name", ":3LDK"},
+		{"empty segment", "恵比寿ビュータワー::3LDK"},
+		{"trailing colon", "恵比寿ビュータワー:"},
+		{"room types twice", "恵比寿ビュータワー:3LDK:4LDK"},
+		{"blank room type", "恵比寿ビュータワー:3LDK& &4LDK"},
+		{"duplicate room type", "恵比寿ビュータワー:3LDK&3LDK"},
+		{"duplicate keep", "恵比寿ビュータワー:keep:keep"},
+		{"keep and 継続", "恵比寿ビュータワー:keep:継続"},
+		{"inverted floor range", "恵比寿ビュータワー:floor>=10:floor<=3"},
+		{"unsupported operator", "恵比寿ビュータワー:rent<150000"},
+		{"rent lower bound", "恵比寿ビュータワー:rent>=100000"},
+		{"area upper bound", "恵比寿ビュータワー:area<=60"},
+		{"万 on area", "恵比寿ビュータワー:area>=6万"},
+		{"fractional floor", "恵比寿ビュータワー:floor>=2.5"},
+		{"non-numeric value", "恵比寿ビュータワー:rent<=安い"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse(%q) = %+v, %v, want a *SyntaxError", tt.text, got, err)
+			}
+		})
+	}
+}
+
+func TestSubscribeStringRoundTrips(t *testing.T) {
+	for _, text := range []string{
+		"恵比寿ビュータワー",
+		"恵比寿ビュータワー:3LDK&4LDK",
+		"恵比寿ビュータワー:3LDK:rent<=150000:area>=60:floor>=3:floor<=10:keep",
+		"恵比寿ビュータワー:floor=5",
+	} {
+		cmd, err := Parse(text)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", text, err)
+		}
+		if got := cmd.(Subscribe).String(); got != text {
+			t.Errorf("Parse(%q).String() = %q", text, got)
+		}
+	}
+}
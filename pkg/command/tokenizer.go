@@ -0,0 +1,61 @@
+package command
+
+import "strings"
+
+// TokenKind identifies the kind of a Token
+type TokenKind int
+
+const (
+	// TokenDash is a leading "-" marking an unsubscribe
+	TokenDash TokenKind = iota
+	// TokenColon separates the property name from its options
+	TokenColon
+	// TokenText is any run of text between separators, trimmed of spaces
+	TokenText
+)
+
+// Token is a lexical element of a chat message
+type Token struct {
+	Kind  TokenKind
+	Value string
+}
+
+// Tokenize splits a chat message into tokens. Full-width separators
+// ("－", "：") are treated the same as their ASCII forms, since they are what
+// Japanese keyboards produce by default.
+func Tokenize(text string) []Token {
+	text = strings.TrimSpace(text)
+
+	var tokens []Token
+	if rest, ok := trimDash(text); ok {
+		tokens = append(tokens, Token{Kind: TokenDash, Value: "-"})
+		text = rest
+	}
+
+	var b strings.Builder
+	flush := func() {
+		tokens = append(tokens, Token{Kind: TokenText, Value: strings.TrimSpace(b.String())})
+		b.Reset()
+	}
+	for _, r := range text {
+		if r == ':' || r == '：' {
+			flush()
+			tokens = append(tokens, Token{Kind: TokenColon, Value: ":"})
+			continue
+		}
+		b.WriteRune(r)
+	}
+	flush()
+
+	return tokens
+}
+
+// trimDash removes a leading ASCII or full-width dash
+func trimDash(text string) (string, bool) {
+	for _, dash := range []string{"-", "－"} {
+		if strings.HasPrefix(text, dash) {
+			return strings.TrimSpace(text[len(dash):]), true
+		}
+	}
+	return text, false
+}
@@ -0,0 +1,40 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	text := func(value string) Token { return Token{Kind: TokenText, Value: value} }
+	colon := Token{Kind: TokenColon, Value: ":"}
+	dash := Token{Kind: TokenDash, Value: "-"}
+
+	tests := []struct {
+		name string
+		in   string
+		want []Token
+	}{
+		{"name", "恵比寿ビュータワー", []Token{text("恵比寿ビュータワー")}},
+		{"options", "恵比寿ビュータワー:3LDK&4LDK:keep",
+			[]Token{text("恵比寿ビュータワー"), colon, text("3LDK&4LDK"), colon, text("keep")}},
+		{"full-width colon", "恵比寿ビュータワー：3LDK＆4LDK",
+			[]Token{text("恵比寿ビュータワー"), colon, text("3LDK＆4LDK")}},
+		{"spaces are trimmed", "  恵比寿 ビュータワー : 3LDK ",
+			[]Token{text("恵比寿 ビュータワー"), colon, text("3LDK")}},
+		{"empty segments", "名前::3LDK:",
+			[]Token{text("名前"), colon, text(""), colon, text("3LDK"), colon, text("")}},
+		{"dash", "-恵比寿ビュータワー", []Token{dash, text("恵比寿ビュータワー")}},
+		{"full-width dash with spaces", "  －  恵比寿ビュータワー ", []Token{dash, text("恵比寿ビュータワー")}},
+		// Only a leading dash marks an unsubscribe
+		{"dash inside a name", "恵比寿-ビュータワー", []Token{text("恵比寿-ビュータワー")}},
+		{"empty", "", []Token{text("")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Tokenize(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,81 +2,300 @@ package line
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// DefaultBaseURL is the LINE Messaging API endpoint used unless WithBaseURL is given
+const DefaultBaseURL = "https://api.line.me"
+
+// DefaultTimeout is the HTTP timeout used unless WithTimeout or WithHTTPClient is given
+const DefaultTimeout = 30 * time.Second
+
 // LineClient represents a LINE messaging API client
 type LineClient struct {
 	channelToken string
+	baseURL      string
+	userAgent    string
 	httpClient   *http.Client
+	retry        retryPolicy
+}
+
+// Option configures a LineClient
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+	userAgent  string
+}
+
+// WithBaseURL sends requests to baseURL instead of DefaultBaseURL, e.g. a
+// mock LINE server. An empty URL keeps the default.
+func WithBaseURL(baseURL string) Option {
+	return func(o *clientOptions) {
+		if baseURL != "" {
+			o.baseURL = strings.TrimRight(baseURL, "/")
+		}
+	}
+}
+
+// WithHTTPClient sends requests through httpClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *clientOptions) {
+		o.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets the timeout of each HTTP attempt. It applies to a copy of
+// the client given to WithHTTPClient, which is left untouched.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every request
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
 }
 
 // NewClient creates a new LINE client
-func NewLineClient(channelToken string) *LineClient {
+func NewLineClient(channelToken string, opts ...Option) *LineClient {
+	o := clientOptions{baseURL: DefaultBaseURL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	httpClient := o.httpClient
+	switch {
+	case httpClient == nil:
+		timeout := o.timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		httpClient = &http.Client{Timeout: timeout}
+	case o.timeout > 0:
+		copied := *httpClient
+		copied.Timeout = o.timeout
+		httpClient = &copied
+	}
+
 	return &LineClient{
 		channelToken: channelToken,
-		httpClient:   &http.Client{},
+		baseURL:      o.baseURL,
+		userAgent:    o.userAgent,
+		httpClient:   httpClient,
+		retry:        defaultRetryPolicy,
 	}
 }
 
 // SendPushMessage sends a push message to a LINE user
-func (c *LineClient) SendPushMessage(userID, message string) error {
+func (c *LineClient) SendPushMessage(ctx context.Context, userID, message string) error {
+	return c.SendPushMessages(ctx, userID, TextMessage{Text: message})
+}
+
+// SendReplyMessage sends a reply message to a LINE user
+func (c *LineClient) SendReplyMessage(ctx context.Context, replyToken, message string) error {
+	return c.SendReplyMessages(ctx, replyToken, TextMessage{Text: message})
+}
+
+// SendPushMessages sends up to five messages to a LINE user in one push
+func (c *LineClient) SendPushMessages(ctx context.Context, userID string, messages ...Message) error {
+	if err := validateMessages(messages); err != nil {
+		return err
+	}
+
 	payload := map[string]interface{}{
-		"to": userID,
-		"messages": []map[string]string{
-			{
-				"type": "text",
-				"text": message,
-			},
-		},
+		"to":       userID,
+		"messages": messages,
 	}
 
-	return c.sendRequest("https://api.line.me/v2/bot/message/push", payload)
+	return c.sendRequest(ctx, c.baseURL+"/v2/bot/message/push", payload, true)
 }
 
-// SendReplyMessage sends a reply message to a LINE user
-func (c *LineClient) SendReplyMessage(replyToken, message string) error {
+// SendReplyMessages sends up to five messages in reply to a webhook event.
+// Replies are not retried: LINE has no retry key for them and a reply token
+// can only be used once.
+func (c *LineClient) SendReplyMessages(ctx context.Context, replyToken string, messages ...Message) error {
+	if err := validateMessages(messages); err != nil {
+		return err
+	}
+
 	payload := map[string]interface{}{
 		"replyToken": replyToken,
-		"messages": []map[string]string{
-			{
-				"type": "text",
-				"text": message,
-			},
-		},
+		"messages":   messages,
+	}
+
+	return c.sendRequest(ctx, c.baseURL+"/v2/bot/message/reply", payload, false)
+}
+
+// maxMulticastRecipients is LINE's limit on recipients per multicast request
+const maxMulticastRecipients = 500
+
+// MulticastError is returned by Multicast when some recipients were not
+// sent to. LINE accepts or rejects a multicast request as a whole, so unless
+// the request was retried per recipient, every recipient of a failed chunk is
+// reported with that chunk's error.
+type MulticastError struct {
+	Failed map[string]error
+	Total  int
+}
+
+func (e *MulticastError) Error() string {
+	return fmt.Sprintf("multicast failed for %d of %d recipients", len(e.Failed), e.Total)
+}
+
+// Multicast sends the same messages to many users, split into requests of
+// at most 500 recipients. LINE rejects a whole request with 400 if a single
+// user ID is invalid, so such a chunk is sent again as one push per user. If
+// any recipient could not be sent to it returns a *MulticastError listing
+// them; the other recipients were sent to.
+func (c *LineClient) Multicast(ctx context.Context, userIDs []string, messages ...Message) error {
+	if err := validateMessages(messages); err != nil {
+		return err
+	}
+
+	failed := make(map[string]error)
+	for start := 0; start < len(userIDs); start += maxMulticastRecipients {
+		chunk := userIDs[start:min(start+maxMulticastRecipients, len(userIDs))]
+
+		payload := map[string]interface{}{
+			"to":       chunk,
+			"messages": messages,
+		}
+
+		err := c.sendRequest(ctx, c.baseURL+"/v2/bot/message/multicast", payload, true)
+		var apiErr *APIError
+		switch {
+		case err == nil:
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest && len(chunk) > 1:
+			log.Printf("LINE multicast to %d users was rejected, pushing to each instead: %v", len(chunk), err)
+			for _, userID := range chunk {
+				if err := c.SendPushMessages(ctx, userID, messages...); err != nil {
+					failed[userID] = err
+				}
+			}
+		default:
+			for _, userID := range chunk {
+				failed[userID] = err
+			}
+		}
 	}
 
-	return c.sendRequest("https://api.line.me/v2/bot/message/reply", payload)
+	if len(failed) > 0 {
+		return &MulticastError{Failed: failed, Total: len(userIDs)}
+	}
+	return nil
 }
 
-// sendRequest sends a request to the LINE API
-func (c *LineClient) sendRequest(url string, payload interface{}) error {
+// Broadcast sends messages to every user who has added the bot as a friend
+func (c *LineClient) Broadcast(ctx context.Context, messages ...Message) error {
+	if err := validateMessages(messages); err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"messages": messages,
+	}
+
+	return c.sendRequest(ctx, c.baseURL+"/v2/bot/message/broadcast", payload, true)
+}
+
+// validateMessages checks the message count against LINE's per-request limits
+func validateMessages(messages []Message) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("no messages to send")
+	}
+	if len(messages) > maxMessagesPerRequest {
+		return fmt.Errorf("too many messages: %d (max %d)", len(messages), maxMessagesPerRequest)
+	}
+	return nil
+}
+
+// sendRequest sends a request to the LINE API. When retry is set, rate
+// limited (429) and failed (5xx) requests are retried with exponential
+// backoff until ctx is done, and every attempt carries the same
+// X-Line-Retry-Key so that LINE delivers the messages at most once even if
+// an earlier attempt was accepted. Otherwise a single attempt is made.
+func (c *LineClient) sendRequest(ctx context.Context, url string, payload interface{}, retry bool) error {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
+	if !retry {
+		return c.doRequest(ctx, url, payloadBytes, "")
+	}
+
+	retryKey, err := newRetryKey()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.doRequest(ctx, url, payloadBytes, retryKey)
+		if err == nil {
+			return nil
+		}
+
+		delay, ok := c.retry.nextDelay(attempt, err)
+		if !ok || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("LINE API request to %s failed (attempt %d), retrying in %v: %v", url, attempt+1, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (gave up retrying: %w)", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// doRequest makes a single attempt at a LINE API request
+func (c *LineClient) doRequest(ctx context.Context, url string, payloadBytes []byte, retryKey string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payloadBytes))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.channelToken)
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if retryKey != "" {
+		req.Header.Set("X-Line-Retry-Key", retryKey)
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("LINE API error: %s (status code: %d)", string(body), resp.StatusCode)
+	if resp.StatusCode == http.StatusOK {
+		return nil
 	}
 
-	return nil
-} 
+	// A conflict on a retried request means an earlier attempt was accepted
+	if resp.StatusCode == http.StatusConflict && retryKey != "" && resp.Header.Get("X-Line-Accepted-Request-Id") != "" {
+		return nil
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	return newAPIError(resp, body)
+}
@@ -0,0 +1,280 @@
+package line
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingServer answers LINE API requests with the given statuses in
+// turn, repeating the last one, and records the retry key of each request
+type recordingServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	statuses  []int
+	header    http.Header
+	retryKeys []string
+	paths     []string
+}
+
+func newRecordingServer(t *testing.T, header http.Header, statuses ...int) *recordingServer {
+	t.Helper()
+	s := &recordingServer{statuses: statuses, header: header}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		attempt := len(s.retryKeys)
+		s.retryKeys = append(s.retryKeys, r.Header.Get("X-Line-Retry-Key"))
+		s.paths = append(s.paths, r.URL.Path)
+		status := s.statuses[min(attempt, len(s.statuses)-1)]
+		s.mu.Unlock()
+
+		if status != http.StatusOK {
+			for key, values := range s.header {
+				w.Header()[key] = values
+			}
+		}
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte(`{"message":"test error"}`))
+		} else {
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordingServer) attempts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.retryKeys...)
+}
+
+// newTestClient returns a client for server that backs off for a millisecond
+// instead of half a second
+func newTestClient(server *recordingServer) *LineClient {
+	client := NewLineClient("token", WithBaseURL(server.URL))
+	client.retry = retryPolicy{maxAttempts: 4, baseDelay: time.Millisecond, maxDelay: 5 * time.Second}
+	return client
+}
+
+func TestPushRetriesRateLimitAfterRetryAfter(t *testing.T) {
+	server := newRecordingServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests, http.StatusOK)
+	client := newTestClient(server)
+
+	start := time.Now()
+	if err := client.SendPushMessage(context.Background(), "U1", "hello"); err != nil {
+		t.Fatalf("SendPushMessage() error = %v", err)
+	}
+
+	if got := len(server.attempts()); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the 1s Retry-After", elapsed)
+	}
+}
+
+func TestPushRetriesServerErrors(t *testing.T) {
+	server := newRecordingServer(t, nil, http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK)
+	client := newTestClient(server)
+
+	if err := client.SendPushMessage(context.Background(), "U1", "hello"); err != nil {
+		t.Fatalf("SendPushMessage() error = %v", err)
+	}
+	if got := len(server.attempts()); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestPushGivesUpAfterMaxAttempts(t *testing.T) {
+	server := newRecordingServer(t, nil, http.StatusInternalServerError)
+	client := newTestClient(server)
+
+	err := client.SendPushMessage(context.Background(), "U1", "hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("SendPushMessage() error = %v, want a 500 APIError", err)
+	}
+	if got := len(server.attempts()); got != 4 {
+		t.Errorf("attempts = %d, want 4", got)
+	}
+}
+
+func TestPushDoesNotRetryBadRequest(t *testing.T) {
+	server := newRecordingServer(t, nil, http.StatusBadRequest, http.StatusOK)
+	client := newTestClient(server)
+
+	err := client.SendPushMessage(context.Background(), "U1", "hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("SendPushMessage() error = %v, want a 400 APIError", err)
+	}
+	if apiErr.Message != "test error" {
+		t.Errorf("Message = %q, want %q", apiErr.Message, "test error")
+	}
+	if got := len(server.attempts()); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestPushReusesRetryKey(t *testing.T) {
+	server := newRecordingServer(t, nil, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	client := newTestClient(server)
+
+	if err := client.SendPushMessage(context.Background(), "U1", "hello"); err != nil {
+		t.Fatalf("SendPushMessage() error = %v", err)
+	}
+
+	keys := server.attempts()
+	if len(keys) != 3 {
+		t.Fatalf("attempts = %d, want 3", len(keys))
+	}
+	if len(keys[0]) != 36 {
+		t.Errorf("retry key = %q, want a UUID", keys[0])
+	}
+	for i, key := range keys {
+		if key != keys[0] {
+			t.Errorf("attempt %d retry key = %q, want %q", i+1, key, keys[0])
+		}
+	}
+
+	// A new request gets a new key
+	if err := client.SendPushMessage(context.Background(), "U1", "hello"); err != nil {
+		t.Fatalf("SendPushMessage() error = %v", err)
+	}
+	if keys := server.attempts(); keys[3] == keys[0] {
+		t.Errorf("second request reused retry key %q", keys[0])
+	}
+}
+
+func TestPushTreatsAcceptedConflictAsSent(t *testing.T) {
+	server := newRecordingServer(t, http.Header{"X-Line-Accepted-Request-Id": {"abc"}}, http.StatusInternalServerError, http.StatusConflict)
+	client := newTestClient(server)
+
+	if err := client.SendPushMessage(context.Background(), "U1", "hello"); err != nil {
+		t.Fatalf("SendPushMessage() error = %v", err)
+	}
+	if got := len(server.attempts()); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+}
+
+func TestReplyIsNotRetried(t *testing.T) {
+	server := newRecordingServer(t, nil, http.StatusInternalServerError, http.StatusOK)
+	client := newTestClient(server)
+
+	err := client.SendReplyMessage(context.Background(), "reply-token", "hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("SendReplyMessage() error = %v, want a 500 APIError", err)
+	}
+
+	keys := server.attempts()
+	if len(keys) != 1 {
+		t.Fatalf("attempts = %d, want 1", len(keys))
+	}
+	if keys[0] != "" {
+		t.Errorf("reply sent retry key %q", keys[0])
+	}
+	if server.paths[0] != "/v2/bot/message/reply" {
+		t.Errorf("path = %s, want /v2/bot/message/reply", server.paths[0])
+	}
+}
+
+func TestPushStopsRetryingWhenContextIsDone(t *testing.T) {
+	server := newRecordingServer(t, nil, http.StatusInternalServerError)
+	client := newTestClient(server)
+	client.retry.baseDelay = time.Minute
+	client.retry.maxDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.SendPushMessage(ctx, "U1", "hello")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendPushMessage() error = %v, want context.DeadlineExceeded", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Errorf("SendPushMessage() error = %v, want the last APIError too", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %v, want promptly after the deadline", elapsed)
+	}
+	if got := len(server.attempts()); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestMulticastFallsBackToPushOnBadRequest(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		pushed []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			To json.RawMessage `json:"to"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		switch r.URL.Path {
+		case "/v2/bot/message/multicast":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"The property, 'to[1]', in the request body is invalid"}`))
+		case "/v2/bot/message/push":
+			var to string
+			json.Unmarshal(body.To, &to)
+			mu.Lock()
+			pushed = append(pushed, to)
+			mu.Unlock()
+			if to == "invalid" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"message":"The property, 'to', in the request body is invalid"}`))
+				return
+			}
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := NewLineClient("token", WithBaseURL(server.URL))
+
+	err := client.Multicast(context.Background(), []string{"U1", "invalid", "U2"}, TextMessage{Text: "hello"})
+
+	var multicastErr *MulticastError
+	if !errors.As(err, &multicastErr) {
+		t.Fatalf("Multicast() error = %v, want a *MulticastError", err)
+	}
+	if multicastErr.Total != 3 || len(multicastErr.Failed) != 1 || multicastErr.Failed["invalid"] == nil {
+		t.Errorf("Multicast() failed = %v of %d, want only \"invalid\" of 3", multicastErr.Failed, multicastErr.Total)
+	}
+	if len(pushed) != 3 {
+		t.Errorf("pushed to %v, want every recipient", pushed)
+	}
+}
+
+func TestMulticastReportsWholeChunkOnServerError(t *testing.T) {
+	server := newRecordingServer(t, nil, http.StatusInternalServerError)
+	client := newTestClient(server)
+
+	err := client.Multicast(context.Background(), []string{"U1", "U2"}, TextMessage{Text: "hello"})
+
+	var multicastErr *MulticastError
+	if !errors.As(err, &multicastErr) || len(multicastErr.Failed) != 2 {
+		t.Fatalf("Multicast() error = %v, want both recipients failed", err)
+	}
+	for _, path := range server.paths {
+		if path != "/v2/bot/message/multicast" {
+			t.Errorf("requested %s, want only multicast retries", path)
+		}
+	}
+}
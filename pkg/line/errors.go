@@ -0,0 +1,98 @@
+package line
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is a non-200 response from the LINE Messaging API
+type APIError struct {
+	StatusCode int
+	// RequestID is the X-Line-Request-Id header, which LINE support asks for
+	RequestID string
+	// Message and Details are parsed from the error JSON body, if any
+	Message string
+	Details []ErrorDetail
+	// RetryAfter is the delay requested by a Retry-After header, or zero
+	RetryAfter time.Duration
+	// Body is the raw response body, kept when it isn't LINE's error JSON
+	Body string
+}
+
+// ErrorDetail points at the part of the request LINE rejected
+type ErrorDetail struct {
+	Message  string `json:"message"`
+	Property string `json:"property"`
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "LINE API error: ")
+	switch {
+	case e.Message != "":
+		b.WriteString(e.Message)
+	case e.Body != "":
+		b.WriteString(e.Body)
+	default:
+		b.WriteString(http.StatusText(e.StatusCode))
+	}
+	for _, d := range e.Details {
+		fmt.Fprintf(&b, "; %s: %s", d.Property, d.Message)
+	}
+	fmt.Fprintf(&b, " (status code: %d", e.StatusCode)
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, ", request ID: %s", e.RequestID)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// Retryable reports whether the request may succeed if sent again:
+// rate limiting (429) and server errors (5xx)
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// newAPIError builds an APIError from a response and its body
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Line-Request-Id"),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+
+	var parsed struct {
+		Message string        `json:"message"`
+		Details []ErrorDetail `json:"details"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil && parsed.Message != "" {
+		apiErr.Message = parsed.Message
+		apiErr.Details = parsed.Details
+	} else {
+		apiErr.Body = string(body)
+	}
+
+	return apiErr
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date. Missing or invalid values give zero.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package line
+
+import "encoding/json"
+
+// FlexContainer is the top level content of a FlexMessage
+type FlexContainer interface {
+	json.Marshaler
+	flexContainer()
+}
+
+// BubbleContainer is a single Flex card
+type BubbleContainer struct {
+	Size   string          `json:"size,omitempty"`
+	Hero   *ImageComponent `json:"hero,omitempty"`
+	Body   *BoxComponent   `json:"body,omitempty"`
+	Footer *BoxComponent   `json:"footer,omitempty"`
+}
+
+// CarouselContainer is a horizontally scrollable list of up to 12 bubbles
+type CarouselContainer struct {
+	Contents []BubbleContainer `json:"contents"`
+}
+
+func (BubbleContainer) flexContainer()   {}
+func (CarouselContainer) flexContainer() {}
+
+// MarshalJSON implements json.Marshaler
+func (c BubbleContainer) MarshalJSON() ([]byte, error) {
+	type alias BubbleContainer
+	return marshalWithType("bubble", alias(c))
+}
+
+// MarshalJSON implements json.Marshaler
+func (c CarouselContainer) MarshalJSON() ([]byte, error) {
+	type alias CarouselContainer
+	return marshalWithType("carousel", alias(c))
+}
+
+// FlexComponent is an element laid out inside a BoxComponent
+type FlexComponent interface {
+	json.Marshaler
+	flexComponent()
+}
+
+// BoxComponent lays out its contents vertically, horizontally or on top of each other
+type BoxComponent struct {
+	Layout   string          `json:"layout"`
+	Contents []FlexComponent `json:"contents"`
+	Spacing  string          `json:"spacing,omitempty"`
+	Margin   string          `json:"margin,omitempty"`
+}
+
+// TextComponent renders a string
+type TextComponent struct {
+	Text   string `json:"text"`
+	Size   string `json:"size,omitempty"`
+	Weight string `json:"weight,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Wrap   bool   `json:"wrap,omitempty"`
+	Margin string `json:"margin,omitempty"`
+}
+
+// ImageComponent renders an image. URL must be HTTPS.
+type ImageComponent struct {
+	URL         string `json:"url"`
+	Size        string `json:"size,omitempty"`
+	AspectRatio string `json:"aspectRatio,omitempty"`
+	AspectMode  string `json:"aspectMode,omitempty"`
+	Action      Action `json:"action,omitempty"`
+}
+
+// ButtonComponent renders a button performing Action
+type ButtonComponent struct {
+	Action Action `json:"action"`
+	Style  string `json:"style,omitempty"`
+	Height string `json:"height,omitempty"`
+}
+
+// SeparatorComponent draws a horizontal or vertical line
+type SeparatorComponent struct {
+	Margin string `json:"margin,omitempty"`
+}
+
+func (BoxComponent) flexComponent()       {}
+func (TextComponent) flexComponent()      {}
+func (ImageComponent) flexComponent()     {}
+func (ButtonComponent) flexComponent()    {}
+func (SeparatorComponent) flexComponent() {}
+
+// MarshalJSON implements json.Marshaler
+func (c BoxComponent) MarshalJSON() ([]byte, error) {
+	type alias BoxComponent
+	return marshalWithType("box", alias(c))
+}
+
+// MarshalJSON implements json.Marshaler
+func (c TextComponent) MarshalJSON() ([]byte, error) {
+	type alias TextComponent
+	return marshalWithType("text", alias(c))
+}
+
+// MarshalJSON implements json.Marshaler
+func (c ImageComponent) MarshalJSON() ([]byte, error) {
+	type alias ImageComponent
+	return marshalWithType("image", alias(c))
+}
+
+// MarshalJSON implements json.Marshaler
+func (c ButtonComponent) MarshalJSON() ([]byte, error) {
+	type alias ButtonComponent
+	return marshalWithType("button", alias(c))
+}
+
+// MarshalJSON implements json.Marshaler
+func (c SeparatorComponent) MarshalJSON() ([]byte, error) {
+	type alias SeparatorComponent
+	return marshalWithType("separator", alias(c))
+}
@@ -0,0 +1,236 @@
+package line
+
+import "encoding/json"
+
+// maxMessagesPerRequest is the number of messages LINE accepts per push or reply
+const maxMessagesPerRequest = 5
+
+// maxActionLabel is LINE's limit on action labels, in characters
+const maxActionLabel = 20
+
+// Message is a LINE message object that can be sent with the Send* methods
+type Message interface {
+	json.Marshaler
+	message()
+}
+
+// TextMessage is a plain text message
+type TextMessage struct {
+	Text       string      `json:"text"`
+	QuickReply *QuickReply `json:"quickReply,omitempty"`
+}
+
+// ImageMessage is an image message. Both URLs must be HTTPS.
+type ImageMessage struct {
+	OriginalContentURL string      `json:"originalContentUrl"`
+	PreviewImageURL    string      `json:"previewImageUrl"`
+	QuickReply         *QuickReply `json:"quickReply,omitempty"`
+}
+
+// TemplateMessage is a buttons or confirm template message. AltText is shown
+// in notifications and on clients that can't render templates.
+type TemplateMessage struct {
+	AltText    string      `json:"altText"`
+	Template   Template    `json:"template"`
+	QuickReply *QuickReply `json:"quickReply,omitempty"`
+}
+
+// FlexMessage is a Flex message containing a bubble or a carousel
+type FlexMessage struct {
+	AltText    string        `json:"altText"`
+	Contents   FlexContainer `json:"contents"`
+	QuickReply *QuickReply   `json:"quickReply,omitempty"`
+}
+
+// RawMessage is a message that is already encoded as LINE message JSON,
+// e.g. one loaded back from the notification queue
+type RawMessage json.RawMessage
+
+func (RawMessage) message()      {}
+func (TextMessage) message()     {}
+func (ImageMessage) message()    {}
+func (TemplateMessage) message() {}
+func (FlexMessage) message()     {}
+
+// MarshalJSON implements json.Marshaler
+func (m RawMessage) MarshalJSON() ([]byte, error) {
+	if len(m) == 0 {
+		return []byte("null"), nil
+	}
+	return m, nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (m TextMessage) MarshalJSON() ([]byte, error) {
+	type alias TextMessage
+	return marshalWithType("text", alias(m))
+}
+
+// MarshalJSON implements json.Marshaler
+func (m ImageMessage) MarshalJSON() ([]byte, error) {
+	type alias ImageMessage
+	return marshalWithType("image", alias(m))
+}
+
+// MarshalJSON implements json.Marshaler
+func (m TemplateMessage) MarshalJSON() ([]byte, error) {
+	type alias TemplateMessage
+	return marshalWithType("template", alias(m))
+}
+
+// MarshalJSON implements json.Marshaler
+func (m FlexMessage) MarshalJSON() ([]byte, error) {
+	type alias FlexMessage
+	return marshalWithType("flex", alias(m))
+}
+
+// Template is the body of a TemplateMessage
+type Template interface {
+	json.Marshaler
+	template()
+}
+
+// ButtonsTemplate shows an optional image and title, a text and up to four actions
+type ButtonsTemplate struct {
+	ThumbnailImageURL string   `json:"thumbnailImageUrl,omitempty"`
+	Title             string   `json:"title,omitempty"`
+	Text              string   `json:"text"`
+	Actions           []Action `json:"actions"`
+}
+
+// ConfirmTemplate shows a text and exactly two actions
+type ConfirmTemplate struct {
+	Text    string   `json:"text"`
+	Actions []Action `json:"actions"`
+}
+
+func (ButtonsTemplate) template() {}
+func (ConfirmTemplate) template() {}
+
+// MarshalJSON implements json.Marshaler
+func (t ButtonsTemplate) MarshalJSON() ([]byte, error) {
+	type alias ButtonsTemplate
+	return marshalWithType("buttons", alias(t))
+}
+
+// MarshalJSON implements json.Marshaler
+func (t ConfirmTemplate) MarshalJSON() ([]byte, error) {
+	type alias ConfirmTemplate
+	return marshalWithType("confirm", alias(t))
+}
+
+// Action is what happens when a button or quick reply item is tapped
+type Action interface {
+	json.Marshaler
+	action()
+}
+
+// MessageAction sends Text as if the user typed it
+type MessageAction struct {
+	Label string `json:"label"`
+	Text  string `json:"text"`
+}
+
+// URIAction opens URI
+type URIAction struct {
+	Label string `json:"label"`
+	URI   string `json:"uri"`
+}
+
+// PostbackAction sends Data to the webhook as a postback event
+type PostbackAction struct {
+	Label       string `json:"label"`
+	Data        string `json:"data"`
+	DisplayText string `json:"displayText,omitempty"`
+}
+
+func (MessageAction) action()  {}
+func (URIAction) action()      {}
+func (PostbackAction) action() {}
+
+// MarshalJSON implements json.Marshaler. Labels longer than LINE's limit are truncated.
+func (a MessageAction) MarshalJSON() ([]byte, error) {
+	type alias MessageAction
+	a.Label = truncateLabel(a.Label)
+	return marshalWithType("message", alias(a))
+}
+
+// MarshalJSON implements json.Marshaler. Labels longer than LINE's limit are truncated.
+func (a URIAction) MarshalJSON() ([]byte, error) {
+	type alias URIAction
+	a.Label = truncateLabel(a.Label)
+	return marshalWithType("uri", alias(a))
+}
+
+// MarshalJSON implements json.Marshaler. Labels longer than LINE's limit are truncated.
+func (a PostbackAction) MarshalJSON() ([]byte, error) {
+	type alias PostbackAction
+	a.Label = truncateLabel(a.Label)
+	return marshalWithType("postback", alias(a))
+}
+
+// maxQuickReplyItems is LINE's limit on quick reply buttons per message
+const maxQuickReplyItems = 13
+
+// QuickReply holds the buttons shown above the keyboard with a message
+type QuickReply struct {
+	Items []QuickReplyItem `json:"items"`
+}
+
+// QuickReplyItem is a single quick reply button
+type QuickReplyItem struct {
+	ImageURL string `json:"imageUrl,omitempty"`
+	Action   Action `json:"action"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (i QuickReplyItem) MarshalJSON() ([]byte, error) {
+	type alias QuickReplyItem
+	return marshalWithType("action", alias(i))
+}
+
+// NewQuickReply builds a quick reply from actions, keeping at most the
+// number of items LINE allows
+func NewQuickReply(actions ...Action) *QuickReply {
+	if len(actions) > maxQuickReplyItems {
+		actions = actions[:maxQuickReplyItems]
+	}
+
+	items := make([]QuickReplyItem, 0, len(actions))
+	for _, action := range actions {
+		items = append(items, QuickReplyItem{Action: action})
+	}
+	return &QuickReply{Items: items}
+}
+
+// marshalWithType encodes v with an added "type" property
+func marshalWithType(typ string, v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	typeJSON, err := json.Marshal(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	// v always encodes as an object; splice "type" in as its first property
+	out := make([]byte, 0, len(body)+len(typeJSON)+9)
+	out = append(out, `{"type":`...)
+	out = append(out, typeJSON...)
+	if len(body) > 2 {
+		out = append(out, ',')
+	}
+	out = append(out, body[1:]...)
+	return out, nil
+}
+
+// truncateLabel shortens a label to maxActionLabel characters
+func truncateLabel(label string) string {
+	runes := []rune(label)
+	if len(runes) <= maxActionLabel {
+		return label
+	}
+	return string(append(runes[:maxActionLabel-1], '…'))
+}
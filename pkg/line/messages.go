@@ -14,29 +14,51 @@ var MessageTemplates = struct {
 	InvalidUnitName          string
 	DatabaseError           string
 	SubscriptionLimitReached string
+	RoomTypeLimitReached     string
+	PersistentNotAllowed     string
 	SpecifiedRoomTypes       string
 	CurrentSubscriptions      string
 	InvalidFormat            string
+	PersistentMode           string
+	SpecifiedFilters         string
+	Help                     string
+	UnknownCommand           string
+	NoSubscriptions          string
+	SubscriptionStatus       string
+	UnsubscribeAllSuccess    string
+	AmbiguousUnitName        string
 }{
 	WelcomeMessage: `Thank you for following us! 
 
-To subscribe to UR property notifications, please send me the exact name of the property you're interested in. I will notify you when vacancies become available. You can subscribe to one property at a time.
+To subscribe to UR property notifications, please send me the name of the property you're interested in. I will notify you when vacancies become available. How many properties you can subscribe to at once depends on your plan.
 
 You can also specify room types by adding them after the property name with a colon. For example: "恵比寿ビュータワー:3LDK&4LDK" will only notify you about 3LDK and 4LDK units.
 
+You can also add conditions: "rent<=150000" (maximum rent in yen, "rent<=15万" also works), "area>=60" (minimum floor area in ㎡) and "floor>=3" / "floor<=10" (floor range). For example: "恵比寿ビュータワー:3LDK:rent<=150000:area>=60"
+
+By default a property is unsubscribed automatically once you have been notified. Add ":keep" to keep receiving notifications. For example: "恵比寿ビュータワー:3LDK:keep"
+
 To unsubscribe from a property, send "-" followed by the property name. For example: "-恵比寿ビュータワー"
 
 For example, if you want to subscribe to "恵比寿ビュータワー", just send me "恵比寿ビュータワー".
 
+Send "help" at any time to see all commands.
+
 ご利用ありがとうございます！
 
-UR物件の空室通知を受け取るには、ご希望の物件の正確な名称を送信してください。空室が発生した際にお知らせいたします。一度に1物件のみの通知が可能です。
+UR物件の空室通知を受け取るには、ご希望の物件の名称を送信してください。空室が発生した際にお知らせいたします。同時に登録できる物件数はご利用のプランによって異なります。
 
 間取りを指定する場合は、物件名の後にコロンと間取りを追加してください。例：「恵比寿ビュータワー:3LDK&4LDK」と送信すると、3LDKと4LDKの空室のみ通知されます。
 
+条件も指定できます：「rent<=150000」（家賃の上限・円、「rent<=15万」も可）、「area>=60」（最小面積・㎡）、「floor>=3」／「floor<=10」（階数）。例：「恵比寿ビュータワー:3LDK:rent<=150000:area>=60」
+
+通知後、その物件の登録は自動的に解除されます。継続して通知を受け取る場合は「:継続」を追加してください。例：「恵比寿ビュータワー:3LDK:継続」
+
 通知を解除する場合は、「-」の後に物件名を送信してください。例：「-恵比寿ビュータワー」
 
-例えば、「恵比寿ビュータワー」の通知を受け取りたい場合は、「恵比寿ビュータワー」と送信してください。`,
+例えば、「恵比寿ビュータワー」の通知を受け取りたい場合は、「恵比寿ビュータワー」と送信してください。
+
+「ヘルプ」と送信すると、すべてのコマンドを確認できます。`,
 	SubscriptionSuccess: `You have successfully subscribed to UR property %s. You will receive notifications when vacancies become available.
 
 UR %sへの登録が完了しました。空室が発生した際にお知らせいたします。`,
@@ -49,15 +71,24 @@ UR %sの通知登録を解除しました。これ以降、この物件の空室
 	UnsubscribeError: `Failed to unsubscribe from UR property %s. Please try again later.
 
 UR %sの通知登録解除に失敗しました。しばらくしてから再度お試しください。`,
+	AmbiguousUnitName: `Several properties match that name. Please choose one below.
+
+該当する物件が複数あります。以下から選択してください。`,
 	InvalidUnitName: `Invalid property name. Please check the property name and try again.
 
 物件名が正しくありません。正確な物件名を確認の上、再度送信してください。`,
 	DatabaseError: `An error occurred while processing your request. Please try again later.
 
 処理中にエラーが発生しました。しばらくしてから再度お試しください。`,
-	SubscriptionLimitReached: `Currently, each user can only subscribe to notifications for one property at a time.
+	SubscriptionLimitReached: `Your plan allows notifications for up to %d properties at a time. Unsubscribe from a property first to add another.
+
+ご利用のプランでは同時に %d 件の物件まで空室通知を登録できます。別の物件を登録するには、先に登録を解除してください。`,
+	RoomTypeLimitReached: `Your plan allows up to %d room types per property.
+
+ご利用のプランでは一つの物件につき %d 件まで間取りを指定できます。`,
+	PersistentNotAllowed: `Your plan does not include ":keep". Please subscribe without it.
 
-現在、お一人様一つの物件のみ空室通知を登録できます。`,
+ご利用のプランでは「:継続」はご利用いただけません。「:継続」を外して登録してください。`,
 	SpecifiedRoomTypes: `Specified room types: %s
 
 指定された間取り: %s`,
@@ -65,10 +96,43 @@ UR %sの通知登録解除に失敗しました。しばらくしてから再度
 
 現在の登録物件:`,
 	InvalidFormat: `Please enter in the correct format.
-Example: Property Name or Property Name:3LDK&4LDK
+Example: Property Name, Property Name:3LDK&4LDK or Property Name:3LDK&4LDK:rent<=150000:area>=60:keep
 
 正しい形式で入力してください。
-例：マンション名 または マンション名:3LDK&4LDK`,
+例：マンション名、マンション名:3LDK&4LDK または マンション名:3LDK&4LDK:rent<=150000:area>=60:継続`,
+	SpecifiedFilters: `Conditions: %s
+
+条件: %s`,
+	Help: `Commands:
+- Property Name: subscribe (e.g. "恵比寿ビュータワー:3LDK&4LDK:rent<=150000:area>=60:floor>=3:keep")
+- -Property Name: unsubscribe
+- unsubscribe all: unsubscribe from every property
+- list: show your subscriptions
+- status: show the last check time and result of each subscription
+- help: show this message
+
+コマンド一覧:
+- 物件名: 通知登録（例：「恵比寿ビュータワー:3LDK&4LDK:rent<=150000:area>=60:floor>=3:継続」）
+- -物件名: 通知解除
+- 全解除: すべての通知を解除
+- 一覧: 登録中の物件を表示
+- 状況: 各物件の最終確認日時と結果を表示
+- ヘルプ: このメッセージを表示`,
+	UnknownCommand: `Sorry, I didn't understand that message. Send "help" to see all commands.
+
+メッセージを理解できませんでした。「ヘルプ」と送信すると、コマンド一覧を確認できます。`,
+	NoSubscriptions: `You have no subscriptions. Send a property name to subscribe.
+
+登録中の物件はありません。物件名を送信すると通知を登録できます。`,
+	SubscriptionStatus: `Subscription status:
+
+登録物件の状況:`,
+	UnsubscribeAllSuccess: `Unsubscribed from %d properties.
+
+%d 件の物件の通知登録を解除しました。`,
+	PersistentMode: `You will keep receiving notifications for this property until you unsubscribe.
+
+この物件の通知は、登録を解除するまで継続されます。`,
 }
 
 // FormatBilingualMessage formats a bilingual message template with the given arguments.
@@ -0,0 +1,69 @@
+package line
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"time"
+)
+
+// retryPolicy controls how failed requests to the LINE API are retried
+type retryPolicy struct {
+	// maxAttempts is the total number of attempts, including the first
+	maxAttempts int
+	// baseDelay is the wait before the first retry; it doubles every attempt
+	baseDelay time.Duration
+	// maxDelay caps the backoff. A Retry-After longer than this is not
+	// waited out; the error is returned instead.
+	maxDelay time.Duration
+}
+
+// defaultRetryPolicy retries up to three times, waiting 0.5s, 1s and 2s plus jitter
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts: 4,
+	baseDelay:   500 * time.Millisecond,
+	maxDelay:    10 * time.Second,
+}
+
+// nextDelay returns how long to wait before retrying after the given failed
+// attempt (zero based), or false if the request should not be retried
+func (p retryPolicy) nextDelay(attempt int, err error) (time.Duration, bool) {
+	if attempt+1 >= p.maxAttempts {
+		return 0, false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if !apiErr.Retryable() {
+			return 0, false
+		}
+		if apiErr.RetryAfter > 0 {
+			if apiErr.RetryAfter > p.maxDelay {
+				return 0, false
+			}
+			return apiErr.RetryAfter, true
+		}
+	}
+
+	delay := p.baseDelay << attempt
+	if delay <= 0 || delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	// Add up to 50% jitter so that concurrent senders don't retry in lockstep
+	if half := int64(delay / 2); half > 0 {
+		delay += time.Duration(mathrand.Int64N(half))
+	}
+	return min(delay, p.maxDelay), true
+}
+
+// newRetryKey returns a random UUID (version 4) for the X-Line-Retry-Key header
+func newRetryKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate retry key: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
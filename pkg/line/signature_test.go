@@ -0,0 +1,46 @@
+package line
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+const (
+	testChannelSecret = "test-channel-secret"
+	// webhookSignature is the signature of testdata/webhook.json with
+	// testChannelSecret, computed with
+	// openssl dgst -sha256 -hmac test-channel-secret -binary testdata/webhook.json | base64
+	webhookSignature = "kIT+eP0tApN/F8rs2TOUvDHMBoCp9PpLnwZrlHSor5k="
+)
+
+func TestValidateSignature(t *testing.T) {
+	body, err := os.ReadFile("testdata/webhook.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(body, []byte("3LDK"), []byte("4LDK"), 1)
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", testChannelSecret, body, webhookSignature, true},
+		{"tampered body", testChannelSecret, tampered, webhookSignature, false},
+		{"missing signature", testChannelSecret, body, "", false},
+		{"wrong secret", "another-secret", body, webhookSignature, false},
+		{"missing secret", "", body, webhookSignature, false},
+		{"not base64", testChannelSecret, body, "not base64!", false},
+		{"truncated signature", testChannelSecret, body, webhookSignature[:20], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateSignature(tt.secret, tt.body, tt.signature); got != tt.want {
+				t.Errorf("ValidateSignature() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/poprih/ur-monitor/pkg/line"
+)
+
+const (
+	// DefaultBatchSize is how many notifications are claimed at once
+	DefaultBatchSize = 100
+	// DefaultMaxAttempts is how often a notification is tried before it is marked failed
+	DefaultMaxAttempts = 5
+	// DefaultClaimLease is how long a claimed batch is reserved for its
+	// dispatcher. It has to outlast sending the batch, retries included.
+	DefaultClaimLease = 5 * time.Minute
+)
+
+// Dispatcher drains the notification queue. Each batch is claimed by marking
+// it as sending, so concurrent dispatchers never send the same notification
+// and nothing is locked while LINE is called. The outcome of each send is
+// recorded separately. A dispatcher that dies mid-batch leaves its
+// notifications claimed until the lease runs out, after which they are sent
+// again (at-least-once delivery).
+type Dispatcher struct {
+	Queue       Queue
+	Client      *line.LineClient
+	BatchSize   int
+	MaxAttempts int
+	ClaimLease  time.Duration
+}
+
+// Result summarizes a dispatcher run
+type Result struct {
+	Sent    int `json:"sent"`
+	Retried int `json:"retried"`
+	Failed  int `json:"failed"`
+}
+
+// Run sends pending notifications until the queue has no more due
+// notifications or ctx is done
+func (d *Dispatcher) Run(ctx context.Context) (Result, error) {
+	var result Result
+	batchSize := d.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	for ctx.Err() == nil {
+		claimed, err := d.dispatchBatch(ctx, batchSize, &result)
+		if err != nil {
+			return result, err
+		}
+		if claimed < batchSize {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// dispatchBatch claims, sends and updates up to batchSize due notifications
+func (d *Dispatcher) dispatchBatch(ctx context.Context, batchSize int, result *Result) (int, error) {
+	lease := d.ClaimLease
+	if lease <= 0 {
+		lease = DefaultClaimLease
+	}
+	batch, err := d.Queue.Claim(ctx, batchSize, lease)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim notifications: %w", err)
+	}
+
+	// Notifications with identical messages are multicast together
+	groups := make(map[string][]Notification)
+	var order []string
+	for _, n := range batch {
+		key := string(n.Messages)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], n)
+	}
+
+	// Outcomes are recorded even if ctx is cancelled mid-send, so that
+	// delivered notifications are not sent again once the claim expires
+	recordCtx := context.WithoutCancel(ctx)
+	for _, key := range order {
+		group := groups[key]
+		failed := d.send(ctx, group)
+		if err := d.recordGroup(recordCtx, group, failed, result); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(batch), nil
+}
+
+// recordGroup stores the outcome of sending a group all at once
+func (d *Dispatcher) recordGroup(ctx context.Context, group []Notification, failed map[string]error, result *Result) error {
+	outcomes := make([]Outcome, 0, len(group))
+	for _, n := range group {
+		outcomes = append(outcomes, d.outcome(n, failed[n.UserID], result))
+	}
+
+	if err := d.Queue.Record(ctx, outcomes); err != nil {
+		return fmt.Errorf("failed to record notifications: %w", err)
+	}
+	return nil
+}
+
+// send delivers the messages of a group of notifications sharing the same
+// messages and returns the users it could not be delivered to
+func (d *Dispatcher) send(ctx context.Context, group []Notification) map[string]error {
+	failed := make(map[string]error)
+
+	var messages []json.RawMessage
+	if err := json.Unmarshal(group[0].Messages, &messages); err != nil {
+		for _, n := range group {
+			failed[n.UserID] = permanentError{fmt.Errorf("invalid queued messages: %w", err)}
+		}
+		return failed
+	}
+	lineMessages := make([]line.Message, 0, len(messages))
+	for _, m := range messages {
+		lineMessages = append(lineMessages, line.RawMessage(m))
+	}
+
+	if len(group) == 1 {
+		if err := d.Client.SendPushMessages(ctx, group[0].UserID, lineMessages...); err != nil {
+			failed[group[0].UserID] = err
+		}
+		return failed
+	}
+
+	userIDs := make([]string, 0, len(group))
+	for _, n := range group {
+		userIDs = append(userIDs, n.UserID)
+	}
+
+	err := d.Client.Multicast(ctx, userIDs, lineMessages...)
+	if err == nil {
+		return failed
+	}
+
+	var multicastErr *line.MulticastError
+	if errors.As(err, &multicastErr) {
+		return multicastErr.Failed
+	}
+	for _, userID := range userIDs {
+		failed[userID] = err
+	}
+	return failed
+}
+
+// outcome decides what happens to a notification after a delivery attempt.
+// Delivered notifications end their one-shot subscription; failed ones are
+// retried with backoff until MaxAttempts or a permanent error.
+func (d *Dispatcher) outcome(n Notification, sendErr error, result *Result) Outcome {
+	if sendErr == nil {
+		result.Sent++
+		return Outcome{ID: n.ID, SubscriptionID: n.SubscriptionID, Status: StatusSent}
+	}
+
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	if n.Attempts >= maxAttempts || !retryable(sendErr) {
+		log.Printf("Notification %d to user %s failed permanently: %v", n.ID, n.UserID, sendErr)
+		result.Failed++
+		return Outcome{ID: n.ID, SubscriptionID: n.SubscriptionID, Status: StatusFailed, Error: sendErr.Error()}
+	}
+
+	log.Printf("Notification %d to user %s failed (attempt %d), will retry: %v", n.ID, n.UserID, n.Attempts, sendErr)
+	result.Retried++
+	return Outcome{
+		ID:             n.ID,
+		SubscriptionID: n.SubscriptionID,
+		Status:         StatusPending,
+		Error:          sendErr.Error(),
+		RetryAfter:     retryDelay(n.Attempts),
+	}
+}
+
+// retryDelay is the wait before the next attempt: 1, 4, 9, 16... minutes
+func retryDelay(attempts int) time.Duration {
+	return time.Duration(attempts*attempts) * time.Minute
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// retryable reports whether a delivery error may go away on a later attempt.
+// LINE API errors other than 429 and 5xx (e.g. a blocked user) are permanent.
+func retryable(err error) bool {
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+
+	var apiErr *line.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	return true
+}
@@ -0,0 +1,320 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/line"
+	"github.com/poprih/ur-monitor/pkg/notify"
+	"github.com/poprih/ur-monitor/pkg/store"
+)
+
+// fakeLINE is a LINE Messaging API that records the pushes and multicasts
+// it accepts. Users listed in status are refused with that status: a
+// multicast including one is refused as a whole, with 400 if any refusal is
+// a 400 as LINE does for an invalid user ID, so the client falls back to
+// pushes. 429s come with a Retry-After too long for the client to wait out.
+type fakeLINE struct {
+	mu         sync.Mutex
+	status     map[string]int
+	pushes     []string
+	multicasts [][]string
+}
+
+func newFakeLINE(t *testing.T) (*fakeLINE, *line.LineClient) {
+	t.Helper()
+	f := &fakeLINE{status: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			To json.RawMessage `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var to []string
+		switch r.URL.Path {
+		case "/v2/bot/message/push":
+			var userID string
+			json.Unmarshal(body.To, &userID)
+			to = []string{userID}
+		case "/v2/bot/message/multicast":
+			json.Unmarshal(body.To, &to)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		refused := 0
+		for _, userID := range to {
+			if status := f.status[userID]; status != 0 && (refused == 0 || status == http.StatusBadRequest) {
+				refused = status
+			}
+		}
+		if refused != 0 {
+			if refused == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "3600")
+			}
+			http.Error(w, `{"message":"refused"}`, refused)
+			return
+		}
+
+		if r.URL.Path == "/v2/bot/message/push" {
+			f.pushes = append(f.pushes, to[0])
+		} else {
+			sort.Strings(to)
+			f.multicasts = append(f.multicasts, to)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return f, line.NewLineClient("test-token", line.WithBaseURL(server.URL))
+}
+
+// refuse makes the fake refuse requests to userID with status, or accept
+// them again if status is 0
+func (f *fakeLINE) refuse(userID string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status[userID] = status
+}
+
+func (f *fakeLINE) sent() (pushes []string, multicasts [][]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.pushes...), append([][]string(nil), f.multicasts...)
+}
+
+// clock is a settable time for the memory queue
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newQueue(t *testing.T) (*store.Memory, *clock) {
+	t.Helper()
+	memory := store.NewMemory()
+	c := &clock{now: time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)}
+	memory.SetClock(c.Now)
+	return memory, c
+}
+
+// queue adds a notification with a single text message
+func queue(t *testing.T, memory *store.Memory, userID string, subscriptionID int, text string) notify.Notification {
+	t.Helper()
+	n, err := memory.AddNotification(1, notify.Alert{
+		UserID:         userID,
+		SubscriptionID: subscriptionID,
+		Messages:       []line.Message{line.TextMessage{Text: text}},
+	})
+	if err != nil {
+		t.Fatalf("AddNotification() error = %v", err)
+	}
+	return n
+}
+
+func notification(t *testing.T, memory *store.Memory, id int) notify.Notification {
+	t.Helper()
+	for _, n := range memory.Notifications() {
+		if n.ID == id {
+			return n
+		}
+	}
+	t.Fatalf("notification %d is not queued", id)
+	return notify.Notification{}
+}
+
+func run(t *testing.T, dispatcher *notify.Dispatcher) notify.Result {
+	t.Helper()
+	result, err := dispatcher.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	return result
+}
+
+func TestDispatcherMulticastsIdenticalMessages(t *testing.T) {
+	memory, _ := newQueue(t)
+	fake, client := newFakeLINE(t)
+	queue(t, memory, "U1", 0, "空室が出ました")
+	queue(t, memory, "U2", 0, "別の物件に空室が出ました")
+	queue(t, memory, "U3", 0, "空室が出ました")
+
+	dispatcher := &notify.Dispatcher{Queue: memory.Stores().Notifications, Client: client}
+	if result := run(t, dispatcher); result != (notify.Result{Sent: 3}) {
+		t.Errorf("Run() = %+v, want 3 sent", result)
+	}
+
+	pushes, multicasts := fake.sent()
+	if fmt.Sprint(pushes) != "[U2]" || fmt.Sprint(multicasts) != "[[U1 U3]]" {
+		t.Errorf("pushes = %v, multicasts = %v, want U2 pushed and U1 and U3 multicast together", pushes, multicasts)
+	}
+	for _, n := range memory.Notifications() {
+		if n.Status != notify.StatusSent || !n.SentAt.Valid || n.Attempts != 1 {
+			t.Errorf("notification %d: %s after %d attempts, want sent after 1", n.ID, n.Status, n.Attempts)
+		}
+	}
+
+	if result := run(t, dispatcher); result != (notify.Result{}) {
+		t.Errorf("second Run() = %+v, want nothing to send", result)
+	}
+}
+
+func TestDispatcherPushesToEachUserOfARejectedMulticast(t *testing.T) {
+	memory, _ := newQueue(t)
+	fake, client := newFakeLINE(t)
+	for _, userID := range []string{"U1", "Uinvalid", "U3"} {
+		queue(t, memory, userID, 0, "空室が出ました")
+	}
+	fake.refuse("Uinvalid", http.StatusBadRequest)
+
+	dispatcher := &notify.Dispatcher{Queue: memory.Stores().Notifications, Client: client}
+	if result := run(t, dispatcher); result != (notify.Result{Sent: 2, Failed: 1}) {
+		t.Errorf("Run() = %+v, want 2 sent and 1 failed", result)
+	}
+	if pushes, _ := fake.sent(); fmt.Sprint(pushes) != "[U1 U3]" {
+		t.Errorf("pushes = %v, want U1 and U3", pushes)
+	}
+	if n := notification(t, memory, 2); n.Status != notify.StatusFailed || !strings.Contains(n.LastError.String, "refused") {
+		t.Errorf("notification to Uinvalid: %s, %q, want failed with LINE's error", n.Status, n.LastError.String)
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	memory, now := newQueue(t)
+	fake, client := newFakeLINE(t)
+	n := queue(t, memory, "U1", 0, "空室が出ました")
+	fake.refuse("U1", http.StatusTooManyRequests)
+
+	dispatcher := &notify.Dispatcher{Queue: memory.Stores().Notifications, Client: client, MaxAttempts: 3}
+
+	// Attempts 1 and 2 are retried after 1 and 4 minutes
+	for _, step := range []struct {
+		attempt int
+		delay   time.Duration
+	}{{1, time.Minute}, {2, 4 * time.Minute}} {
+		if result := run(t, dispatcher); result != (notify.Result{Retried: 1}) {
+			t.Fatalf("attempt %d: Run() = %+v, want 1 retried", step.attempt, result)
+		}
+		got := notification(t, memory, n.ID)
+		if got.Status != notify.StatusPending || got.Attempts != step.attempt || !got.NextAttemptAt.Equal(now.now.Add(step.delay)) || !got.LastError.Valid {
+			t.Fatalf("attempt %d: %s after %d attempts, next at %v, want pending until %v",
+				step.attempt, got.Status, got.Attempts, got.NextAttemptAt, now.now.Add(step.delay))
+		}
+
+		// Nothing is due until the delay has passed
+		now.now = now.now.Add(step.delay - time.Second)
+		if result := run(t, dispatcher); result != (notify.Result{}) {
+			t.Fatalf("attempt %d: Run() before the retry is due = %+v, want nothing", step.attempt, result)
+		}
+		now.now = now.now.Add(time.Second)
+	}
+
+	// The third attempt is the last
+	if result := run(t, dispatcher); result != (notify.Result{Failed: 1}) {
+		t.Fatalf("attempt 3: Run() = %+v, want 1 failed", result)
+	}
+	if got := notification(t, memory, n.ID); got.Status != notify.StatusFailed || got.Attempts != 3 {
+		t.Errorf("after attempt 3: %s after %d attempts, want failed after 3", got.Status, got.Attempts)
+	}
+	if pushes, _ := fake.sent(); len(pushes) != 0 {
+		t.Errorf("pushes = %v, want none accepted", pushes)
+	}
+}
+
+func TestDispatcherDoesNotRetryPermanentErrors(t *testing.T) {
+	memory, _ := newQueue(t)
+	fake, client := newFakeLINE(t)
+	n := queue(t, memory, "Ublocked", 0, "空室が出ました")
+	fake.refuse("Ublocked", http.StatusForbidden)
+
+	dispatcher := &notify.Dispatcher{Queue: memory.Stores().Notifications, Client: client}
+	if result := run(t, dispatcher); result != (notify.Result{Failed: 1}) {
+		t.Errorf("Run() = %+v, want 1 failed", result)
+	}
+	if got := notification(t, memory, n.ID); got.Status != notify.StatusFailed || got.Attempts != 1 {
+		t.Errorf("notification: %s after %d attempts, want failed after 1", got.Status, got.Attempts)
+	}
+}
+
+func TestDispatcherReclaimsExpiredLeases(t *testing.T) {
+	memory, now := newQueue(t)
+	fake, client := newFakeLINE(t)
+	n := queue(t, memory, "U1", 0, "空室が出ました")
+	queues := memory.Stores().Notifications
+
+	// A dispatcher claims the notification and dies before recording anything
+	if _, err := queues.Claim(context.Background(), 10, 5*time.Minute); err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+
+	dispatcher := &notify.Dispatcher{Queue: queues, Client: client, ClaimLease: 5 * time.Minute}
+	now.now = now.now.Add(4 * time.Minute)
+	if result := run(t, dispatcher); result != (notify.Result{}) {
+		t.Errorf("Run() while claimed = %+v, want nothing", result)
+	}
+
+	now.now = now.now.Add(2 * time.Minute)
+	if result := run(t, dispatcher); result != (notify.Result{Sent: 1}) {
+		t.Errorf("Run() after the claim expired = %+v, want 1 sent", result)
+	}
+	if got := notification(t, memory, n.ID); got.Status != notify.StatusSent || got.Attempts != 2 || got.ClaimedUntil.Valid {
+		t.Errorf("notification: %s after %d attempts, want sent after 2 with no claim", got.Status, got.Attempts)
+	}
+	if pushes, _ := fake.sent(); fmt.Sprint(pushes) != "[U1]" {
+		t.Errorf("pushes = %v, want U1 once", pushes)
+	}
+}
+
+func TestDispatcherEndsOneShotSubscriptionsOnlyAfterDelivery(t *testing.T) {
+	ctx := context.Background()
+	memory, _ := newQueue(t)
+	fake, client := newFakeLINE(t)
+	stores := memory.Stores()
+	unit := memory.AddUnit(models.Unit{Name: "恵比寿ビュータワー"})
+
+	for _, sub := range []models.Subscription{
+		{LineUserID: "Udelivered", UnitID: unit.ID, Mode: models.SubscriptionModeOneShot},
+		{LineUserID: "Uretried", UnitID: unit.ID, Mode: models.SubscriptionModeOneShot},
+		{LineUserID: "Ukept", UnitID: unit.ID, Mode: models.SubscriptionModePersistent},
+	} {
+		memory.SetUser(models.User{LineUserID: sub.LineUserID})
+		if err := stores.Subscriptions.Save(ctx, sub); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	subs, err := stores.Subscriptions.ListByUnit(ctx, unit.ID)
+	if err != nil {
+		t.Fatalf("ListByUnit() error = %v", err)
+	}
+	// Distinct messages are pushed one by one, so only Uretried's is refused
+	for _, sub := range subs {
+		queue(t, memory, sub.LineUserID, sub.ID, "空室が出ました: "+sub.LineUserID)
+	}
+	fake.refuse("Uretried", http.StatusTooManyRequests)
+
+	dispatcher := &notify.Dispatcher{Queue: stores.Notifications, Client: client}
+	if result := run(t, dispatcher); result != (notify.Result{Sent: 2, Retried: 1}) {
+		t.Fatalf("Run() = %+v, want 2 sent and 1 retried", result)
+	}
+
+	for userID, want := range map[string]int{"Udelivered": 0, "Uretried": 1, "Ukept": 1} {
+		if count, _ := stores.Subscriptions.CountActive(ctx, userID); count != want {
+			t.Errorf("%s has %d active subscriptions, want %d", userID, count, want)
+		}
+	}
+}
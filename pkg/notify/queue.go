@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/poprih/ur-monitor/pkg/line"
+)
+
+// Status is the delivery state of a queued notification
+type Status string
+
+const (
+	// StatusPending notifications are waiting to be sent or retried
+	StatusPending Status = "pending"
+	// StatusSending notifications are claimed by a dispatcher until their
+	// claim expires
+	StatusSending Status = "sending"
+	// StatusSent notifications were accepted by LINE
+	StatusSent Status = "sent"
+	// StatusFailed notifications gave up after a permanent error or too many attempts
+	StatusFailed Status = "failed"
+)
+
+// Notification is a queued LINE push to a single user
+type Notification struct {
+	ID             int
+	UserID         string
+	UnitID         int
+	SubscriptionID sql.NullInt64
+	Messages       json.RawMessage
+	Status         Status
+	Attempts       int
+	LastError      sql.NullString
+	NextAttemptAt  time.Time
+	ClaimedUntil   sql.NullTime
+	SentAt         sql.NullTime
+	CreatedAt      time.Time
+}
+
+// Outcome is the result of a delivery attempt of a claimed notification
+type Outcome struct {
+	ID             int
+	SubscriptionID sql.NullInt64
+	// Status is StatusSent, StatusPending to try again after RetryAfter, or
+	// StatusFailed
+	Status     Status
+	Error      string
+	RetryAfter time.Duration
+}
+
+// Queue holds the notifications waiting to be sent. The stores in pkg/store
+// implement it.
+type Queue interface {
+	// Claim marks up to limit notifications as sending until lease runs out
+	// and returns them ordered by id. Due pending notifications are claimed
+	// along with sending ones whose claim has expired. Claiming counts as
+	// an attempt, so a notification that keeps crashing its dispatcher still
+	// runs out of attempts.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Notification, error)
+	// Record stores the outcomes of one send all at once. Notifications
+	// that are no longer claimed are left as they are, but a sent one still
+	// ends its one-shot subscription, as the user has been notified.
+	Record(ctx context.Context, outcomes []Outcome) error
+}
+
+// Alert is a notification about to be queued for a user
+type Alert struct {
+	UserID string
+	// SubscriptionID is the subscription that triggered the alert, or zero
+	SubscriptionID int
+	Messages       []line.Message
+}
+
+// Execer is satisfied by both *sql.DB and *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Enqueue stores messages for delivery to userID. subscriptionID links the
+// notification to the subscription that triggered it, so that one-shot
+// subscriptions can be ended once the messages are delivered; pass zero if
+// there is none.
+func Enqueue(ctx context.Context, db Execer, userID string, unitID, subscriptionID int, messages ...line.Message) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("no messages to enqueue")
+	}
+
+	messagesJSON, err := json.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("failed to encode messages: %w", err)
+	}
+
+	var subscription sql.NullInt64
+	if subscriptionID != 0 {
+		subscription = sql.NullInt64{Int64: int64(subscriptionID), Valid: true}
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO notifications (line_user_id, unit_id, subscription_id, messages)
+		VALUES ($1, $2, $3, $4)`,
+		userID, unitID, subscription, messagesJSON)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue notification: %w", err)
+	}
+	return nil
+}
+
+// EnqueueAlerts stores alerts about a unit for delivery, stopping at the
+// first one that fails. Pass a transaction to queue all or none of them.
+func EnqueueAlerts(ctx context.Context, db Execer, unitID int, alerts []Alert) error {
+	for _, alert := range alerts {
+		if err := Enqueue(ctx, db, alert.UserID, unitID, alert.SubscriptionID, alert.Messages...); err != nil {
+			return fmt.Errorf("failed to queue alert for user %s: %w", alert.UserID, err)
+		}
+	}
+	return nil
+}
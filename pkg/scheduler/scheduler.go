@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Run calls job at every run time of the window until ctx is cancelled. Runs
+// never overlap: a run time that passes while the job is still going is
+// skipped. Each run gets a context bounded by timeout when it is positive.
+func Run(ctx context.Context, window Window, timeout time.Duration, job func(ctx context.Context)) {
+	for {
+		next := window.Next(time.Now())
+		log.Printf("Next scheduled run at %s", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		job(runCtx)
+		cancel()
+	}
+}
@@ -0,0 +1,55 @@
+// Package scheduler runs a job at fixed intervals inside a daily time window,
+// the in-process counterpart of the GitHub Actions cron.
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window describes when a job runs: every Interval from midnight, between
+// Start and End (inclusive) each day in Location.
+type Window struct {
+	Location *time.Location
+	Start    time.Duration
+	End      time.Duration
+	Interval time.Duration
+}
+
+// Validate reports whether the window can produce any run times
+func (w Window) Validate() error {
+	switch {
+	case w.Location == nil:
+		return fmt.Errorf("window has no location")
+	case w.Interval <= 0:
+		return fmt.Errorf("interval must be positive, got %s", w.Interval)
+	case w.Start < 0 || w.End >= 24*time.Hour || w.Start > w.End:
+		return fmt.Errorf("invalid window %s-%s", w.Start, w.End)
+	case w.firstSlot() > w.End:
+		return fmt.Errorf("no %s slot between %s and %s", w.Interval, w.Start, w.End)
+	}
+	return nil
+}
+
+// Next returns the first run time strictly after t
+func (w Window) Next(t time.Time) time.Time {
+	local := t.In(w.Location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, w.Location)
+
+	// Next interval boundary after t, counted from midnight
+	offset := local.Sub(day)
+	slot := (offset/w.Interval + 1) * w.Interval
+	if slot < w.firstSlot() {
+		slot = w.firstSlot()
+	}
+	if slot <= w.End {
+		return day.Add(slot)
+	}
+
+	return day.AddDate(0, 0, 1).Add(w.firstSlot())
+}
+
+// firstSlot is the first interval boundary at or after Start
+func (w Window) firstSlot() time.Duration {
+	return (w.Start + w.Interval - 1) / w.Interval * w.Interval
+}
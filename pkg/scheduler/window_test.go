@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	jst = time.FixedZone("JST", 9*60*60)
+	est = time.FixedZone("EST", -5*60*60)
+)
+
+// window is the default schedule: every 10 minutes from 09:00 to 19:50 JST
+var window = Window{Location: jst, Start: 9 * time.Hour, End: 19*time.Hour + 50*time.Minute, Interval: 10 * time.Minute}
+
+func TestWindowNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		window Window
+		t      time.Time
+		want   time.Time
+	}{
+		{"before the window", window, time.Date(2026, 4, 1, 6, 30, 0, 0, jst), time.Date(2026, 4, 1, 9, 0, 0, 0, jst)},
+		{"just before the first slot", window, time.Date(2026, 4, 1, 8, 59, 59, 0, jst), time.Date(2026, 4, 1, 9, 0, 0, 0, jst)},
+		{"on the first slot", window, time.Date(2026, 4, 1, 9, 0, 0, 0, jst), time.Date(2026, 4, 1, 9, 10, 0, 0, jst)},
+		{"between slots", window, time.Date(2026, 4, 1, 12, 34, 56, 0, jst), time.Date(2026, 4, 1, 12, 40, 0, 0, jst)},
+		{"on a slot", window, time.Date(2026, 4, 1, 12, 40, 0, 0, jst), time.Date(2026, 4, 1, 12, 50, 0, 0, jst)},
+		{"just after a slot", window, time.Date(2026, 4, 1, 12, 40, 0, 1, jst), time.Date(2026, 4, 1, 12, 50, 0, 0, jst)},
+		{"before the last slot", window, time.Date(2026, 4, 1, 19, 45, 0, 0, jst), time.Date(2026, 4, 1, 19, 50, 0, 0, jst)},
+		{"on the last slot", window, time.Date(2026, 4, 1, 19, 50, 0, 0, jst), time.Date(2026, 4, 2, 9, 0, 0, 0, jst)},
+		{"after the window", window, time.Date(2026, 4, 1, 22, 0, 0, 0, jst), time.Date(2026, 4, 2, 9, 0, 0, 0, jst)},
+		{"end of the month", window, time.Date(2026, 4, 30, 23, 59, 0, 0, jst), time.Date(2026, 5, 1, 9, 0, 0, 0, jst)},
+		{"end of the year", window, time.Date(2026, 12, 31, 20, 0, 0, 0, jst), time.Date(2027, 1, 1, 9, 0, 0, 0, jst)},
+		// 00:30 UTC is 09:30 JST; 15:00 UTC is already the next day in JST
+		{"UTC inside the window", window, time.Date(2026, 4, 1, 0, 30, 0, 0, time.UTC), time.Date(2026, 4, 1, 9, 40, 0, 0, jst)},
+		{"UTC after the window", window, time.Date(2026, 4, 1, 11, 0, 0, 0, time.UTC), time.Date(2026, 4, 2, 9, 0, 0, 0, jst)},
+		{"UTC on the next JST day", window, time.Date(2026, 4, 1, 15, 0, 0, 0, time.UTC), time.Date(2026, 4, 2, 9, 0, 0, 0, jst)},
+		// 20:05 EST on March 31 is 10:05 JST on April 1
+		{"EST on the previous day", window, time.Date(2026, 3, 31, 20, 5, 0, 0, est), time.Date(2026, 4, 1, 10, 10, 0, 0, jst)},
+		{
+			name:   "start off the interval",
+			window: Window{Location: jst, Start: 9*time.Hour + 5*time.Minute, End: 10 * time.Hour, Interval: 15 * time.Minute},
+			t:      time.Date(2026, 4, 1, 8, 0, 0, 0, jst),
+			want:   time.Date(2026, 4, 1, 9, 15, 0, 0, jst),
+		},
+		{
+			name:   "end off the interval",
+			window: Window{Location: jst, Start: 9 * time.Hour, End: 10*time.Hour + 5*time.Minute, Interval: 15 * time.Minute},
+			t:      time.Date(2026, 4, 1, 10, 0, 0, 0, jst),
+			want:   time.Date(2026, 4, 2, 9, 0, 0, 0, jst),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.window.Next(tt.t)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+			if got.Location() != jst {
+				t.Errorf("Next(%v) is in %v, want the window's location", tt.t, got.Location())
+			}
+		})
+	}
+}
+
+func TestWindowValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  Window
+		wantErr bool
+	}{
+		{"default", window, false},
+		{"single slot", Window{Location: jst, Start: 9 * time.Hour, End: 9 * time.Hour, Interval: time.Hour}, false},
+		{"no location", Window{Start: 9 * time.Hour, End: 10 * time.Hour, Interval: time.Hour}, true},
+		{"no interval", Window{Location: jst, Start: 9 * time.Hour, End: 10 * time.Hour}, true},
+		{"inverted", Window{Location: jst, Start: 10 * time.Hour, End: 9 * time.Hour, Interval: time.Hour}, true},
+		{"past midnight", Window{Location: jst, Start: 9 * time.Hour, End: 24 * time.Hour, Interval: time.Hour}, true},
+		{"no slot inside", Window{Location: jst, Start: 9*time.Hour + 10*time.Minute, End: 9*time.Hour + 50*time.Minute, Interval: time.Hour}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.window.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package snapshot
+
+import (
+	"sort"
+
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+// Changes describes how the vacant rooms of a unit changed between two checks
+type Changes struct {
+	Appeared    []urclient.Room `json:"appeared"`
+	Disappeared []urclient.Room `json:"disappeared"`
+}
+
+// IsEmpty reports whether nothing changed
+func (c Changes) IsEmpty() bool {
+	return len(c.Appeared) == 0 && len(c.Disappeared) == 0
+}
+
+// Diff compares the previous and current room lists by Room.Key. Rooms are
+// compared as a multiset, so a second vacant 3LDK without a room ID shows up
+// as appeared even if another 3LDK was already vacant. If the previous list
+// predates room IDs, rooms are compared by type only so that upgrading the
+// payload does not report every vacant room as new.
+func Diff(prev, curr []urclient.Room) Changes {
+	key := urclient.Room.Key
+	for _, room := range prev {
+		if room.ID == "" {
+			key = func(r urclient.Room) string { return "type:" + r.Type }
+			break
+		}
+	}
+
+	remaining := make(map[string][]urclient.Room, len(prev))
+	for _, room := range prev {
+		k := key(room)
+		remaining[k] = append(remaining[k], room)
+	}
+
+	var changes Changes
+	for _, room := range curr {
+		k := key(room)
+		if len(remaining[k]) > 0 {
+			remaining[k] = remaining[k][1:]
+			continue
+		}
+		changes.Appeared = append(changes.Appeared, room)
+	}
+
+	keys := make([]string, 0, len(remaining))
+	for k := range remaining {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		changes.Disappeared = append(changes.Disappeared, remaining[k]...)
+	}
+
+	return changes
+}
@@ -0,0 +1,111 @@
+package snapshot
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+func room(id, roomType string) urclient.Room {
+	return urclient.Room{ID: id, Type: roomType}
+}
+
+// rooms lists rooms as id/type in order
+func rooms(list []urclient.Room) string {
+	var s []string
+	for _, r := range list {
+		s = append(s, r.ID+"/"+r.Type)
+	}
+	return fmt.Sprint(s)
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name            string
+		prev, curr      []urclient.Room
+		wantAppeared    string
+		wantDisappeared string
+	}{
+		{
+			name:            "first check",
+			curr:            []urclient.Room{room("101", "2LDK"), room("102", "3LDK")},
+			wantAppeared:    "[101/2LDK 102/3LDK]",
+			wantDisappeared: "[]",
+		},
+		{
+			name:            "unchanged in a different order",
+			prev:            []urclient.Room{room("101", "2LDK"), room("102", "3LDK")},
+			curr:            []urclient.Room{room("102", "3LDK"), room("101", "2LDK")},
+			wantAppeared:    "[]",
+			wantDisappeared: "[]",
+		},
+		{
+			name:            "added and removed",
+			prev:            []urclient.Room{room("101", "2LDK"), room("102", "3LDK"), room("201", "1K")},
+			curr:            []urclient.Room{room("102", "3LDK"), room("301", "2LDK")},
+			wantAppeared:    "[301/2LDK]",
+			wantDisappeared: "[101/2LDK 201/1K]",
+		},
+		{
+			name:            "same type, different room",
+			prev:            []urclient.Room{room("101", "3LDK")},
+			curr:            []urclient.Room{room("102", "3LDK")},
+			wantAppeared:    "[102/3LDK]",
+			wantDisappeared: "[101/3LDK]",
+		},
+		{
+			name:            "all removed",
+			prev:            []urclient.Room{room("101", "2LDK"), room("102", "3LDK")},
+			wantAppeared:    "[]",
+			wantDisappeared: "[101/2LDK 102/3LDK]",
+		},
+		{
+			name:            "duplicate appears",
+			prev:            []urclient.Room{room("101", "3LDK")},
+			curr:            []urclient.Room{room("101", "3LDK"), room("101", "3LDK")},
+			wantAppeared:    "[101/3LDK]",
+			wantDisappeared: "[]",
+		},
+		{
+			name:            "duplicate disappears",
+			prev:            []urclient.Room{room("101", "3LDK"), room("101", "3LDK")},
+			curr:            []urclient.Room{room("101", "3LDK")},
+			wantAppeared:    "[]",
+			wantDisappeared: "[101/3LDK]",
+		},
+		{
+			name:            "second vacancy of a type without IDs",
+			prev:            []urclient.Room{room("", "3LDK")},
+			curr:            []urclient.Room{room("", "3LDK"), room("", "3LDK"), room("", "2DK")},
+			wantAppeared:    "[/3LDK /2DK]",
+			wantDisappeared: "[]",
+		},
+		{
+			name:            "legacy snapshot compared by type",
+			prev:            []urclient.Room{room("", "2LDK"), room("", "3LDK")},
+			curr:            []urclient.Room{room("101", "2LDK"), room("102", "3LDK")},
+			wantAppeared:    "[]",
+			wantDisappeared: "[]",
+		},
+		{
+			name:            "legacy snapshot with changed types",
+			prev:            []urclient.Room{room("", "2LDK"), room("", "3LDK"), room("", "3LDK")},
+			curr:            []urclient.Room{room("102", "3LDK"), room("201", "1K")},
+			wantAppeared:    "[201/1K]",
+			wantDisappeared: "[/2LDK /3LDK]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(tt.prev, tt.curr)
+			if rooms(got.Appeared) != tt.wantAppeared || rooms(got.Disappeared) != tt.wantDisappeared {
+				t.Errorf("Diff() appeared %s, disappeared %s, want %s, %s",
+					rooms(got.Appeared), rooms(got.Disappeared), tt.wantAppeared, tt.wantDisappeared)
+			}
+			if got.IsEmpty() != (tt.wantAppeared == "[]" && tt.wantDisappeared == "[]") {
+				t.Errorf("Diff().IsEmpty() = %v", got.IsEmpty())
+			}
+		})
+	}
+}
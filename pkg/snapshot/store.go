@@ -0,0 +1,150 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+// Snapshot is the last seen list of vacant rooms for a unit
+type Snapshot struct {
+	ID        int
+	UnitID    int
+	Rooms     []urclient.Room
+	Changes   Changes
+	CreatedAt time.Time
+	CheckedAt time.Time
+}
+
+// Querier is satisfied by both *sql.DB and *sql.Tx
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Latest returns the most recent snapshot for a unit, or nil if the unit
+// has never been checked
+func Latest(ctx context.Context, db Querier, unitID int) (*Snapshot, error) {
+	var s Snapshot
+	var roomsJSON, appearedJSON, disappearedJSON []byte
+	err := db.QueryRowContext(ctx, `
+		SELECT id, unit_id, rooms, appeared, disappeared, created_at, checked_at
+		FROM room_snapshots
+		WHERE unit_id = $1
+		ORDER BY id DESC
+		LIMIT 1`, unitID).Scan(&s.ID, &s.UnitID, &roomsJSON, &appearedJSON, &disappearedJSON, &s.CreatedAt, &s.CheckedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query room snapshot: %w", err)
+	}
+
+	if err := json.Unmarshal(roomsJSON, &s.Rooms); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot rooms: %w", err)
+	}
+	if err := json.Unmarshal(appearedJSON, &s.Changes.Appeared); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot appeared rooms: %w", err)
+	}
+	if err := json.Unmarshal(disappearedJSON, &s.Changes.Disappeared); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot disappeared rooms: %w", err)
+	}
+
+	return &s, nil
+}
+
+// Record stores the rooms seen by a check and returns what changed since the
+// previous snapshot. A new history row is only written when something
+// changed; otherwise the latest row's checked_at is bumped. Use Update when
+// the changes trigger further writes.
+func Record(ctx context.Context, db Querier, unitID int, rooms []urclient.Room) (Changes, error) {
+	prev, err := Latest(ctx, db, unitID)
+	if err != nil {
+		return Changes{}, err
+	}
+
+	var prevRooms []urclient.Room
+	if prev != nil {
+		prevRooms = prev.Rooms
+	}
+	changes := Diff(prevRooms, rooms)
+
+	if prev != nil && changes.IsEmpty() {
+		_, err = db.ExecContext(ctx, "UPDATE room_snapshots SET checked_at = NOW() WHERE id = $1", prev.ID)
+		if err != nil {
+			return Changes{}, fmt.Errorf("failed to update room snapshot: %w", err)
+		}
+		return changes, nil
+	}
+
+	roomsJSON, err := marshalRooms(rooms)
+	if err != nil {
+		return Changes{}, err
+	}
+	appearedJSON, err := marshalRooms(changes.Appeared)
+	if err != nil {
+		return Changes{}, err
+	}
+	disappearedJSON, err := marshalRooms(changes.Disappeared)
+	if err != nil {
+		return Changes{}, err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO room_snapshots (unit_id, room_count, rooms, appeared, disappeared)
+		VALUES ($1, $2, $3, $4, $5)`,
+		unitID, len(rooms), roomsJSON, appearedJSON, disappearedJSON)
+	if err != nil {
+		return Changes{}, fmt.Errorf("failed to insert room snapshot: %w", err)
+	}
+
+	return changes, nil
+}
+
+// Update records rooms like Record and passes the changes to queue, all in
+// one transaction that holds the unit's row lock. Concurrent checks of a
+// unit therefore diff against each other's snapshots rather than the same
+// one, and a snapshot is only saved together with the writes queue makes
+// for it: if queue fails, nothing is saved and the next check reports the
+// same changes again.
+func Update(ctx context.Context, db *sql.DB, unitID int, rooms []urclient.Room, queue func(tx *sql.Tx, changes Changes) error) (Changes, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Changes{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM units WHERE id = $1 FOR UPDATE", unitID).Scan(&id); err != nil {
+		return Changes{}, fmt.Errorf("failed to lock unit %d: %w", unitID, err)
+	}
+
+	changes, err := Record(ctx, tx, unitID, rooms)
+	if err != nil {
+		return Changes{}, err
+	}
+	if err := queue(tx, changes); err != nil {
+		return Changes{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Changes{}, fmt.Errorf("failed to commit room snapshot: %w", err)
+	}
+	return changes, nil
+}
+
+// marshalRooms encodes rooms as a JSON array, never as null
+func marshalRooms(rooms []urclient.Room) ([]byte, error) {
+	if rooms == nil {
+		rooms = []urclient.Room{}
+	}
+	data, err := json.Marshal(rooms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode rooms: %w", err)
+	}
+	return data, nil
+}
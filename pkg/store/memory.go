@@ -0,0 +1,969 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/checkcursor"
+	"github.com/poprih/ur-monitor/pkg/notify"
+	"github.com/poprih/ur-monitor/pkg/snapshot"
+	"github.com/poprih/ur-monitor/pkg/unitsearch"
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+// Memory holds users, units, subscriptions, room snapshots, queued alerts
+// and notifications and check cursors in memory. It behaves like the
+// Postgres stores, including soft deletes, and is meant for tests.
+type Memory struct {
+	mu            sync.Mutex
+	users         map[string]models.User
+	units         map[int]models.Unit
+	checks        map[int]models.UnitCheck
+	rooms         map[int][]urclient.Room
+	alerts        []QueuedAlert
+	notifications []*notify.Notification
+	cursors       map[checkcursor.Shard]Cursor
+	lockedShards  map[checkcursor.Shard]bool
+	subscriptions []*memorySubscription
+	plans         []models.Plan
+	userPlans     map[string]models.UserPlan
+	planChanges   []models.PlanChange
+	prefectures   []models.Prefecture
+	areas         map[memoryArea]int
+	skcs          map[memorySKC]int
+	nextUnitID    int
+	nextSubID     int
+	// now is the clock of the notification queue
+	now func() time.Time
+}
+
+// memoryArea and memorySKC are the unique keys of areas and skcs
+type (
+	memoryArea struct {
+		prefectureID int
+		code         string
+	}
+	memorySKC struct {
+		areaID int
+		urID   int
+	}
+)
+
+// QueuedAlert is an alert the memory RoomStore queued for a unit
+type QueuedAlert struct {
+	UnitID int
+	notify.Alert
+}
+
+// Cursor is the progress of a shard in the memory CursorStore
+type Cursor struct {
+	LastUnitID      int
+	CyclesCompleted int
+}
+
+type memorySubscription struct {
+	models.Subscription
+	deleted bool
+}
+
+// NewMemory returns an in-memory database holding only the free and premium
+// plans, like a freshly migrated one
+func NewMemory() *Memory {
+	one := 1
+	return &Memory{
+		users:        make(map[string]models.User),
+		units:        make(map[int]models.Unit),
+		checks:       make(map[int]models.UnitCheck),
+		rooms:        make(map[int][]urclient.Room),
+		cursors:      make(map[checkcursor.Shard]Cursor),
+		lockedShards: make(map[checkcursor.Shard]bool),
+		areas:        make(map[memoryArea]int),
+		skcs:         make(map[memorySKC]int),
+		userPlans:    make(map[string]models.UserPlan),
+		now:          time.Now,
+		plans: []models.Plan{
+			{ID: 1, Code: "free", Name: "Free", MaxSubscriptions: &one, CheckInterval: 10 * time.Minute, AllowPersistent: true, IsDefault: true},
+			{ID: 2, Code: "premium", Name: "Premium", CheckInterval: 10 * time.Minute, AllowPersistent: true},
+		},
+	}
+}
+
+// Stores returns stores backed by m
+func (m *Memory) Stores() Stores {
+	return Stores{
+		Users:         memoryUsers{m},
+		Units:         memoryUnits{m},
+		Subscriptions: memorySubscriptions{m},
+		Plans:         memoryPlans{m},
+		Rooms:         memoryRooms{m},
+		Cursors:       memoryCursors{m},
+		Catalog:       memoryCatalog{m},
+		Notifications: memoryNotifications{m},
+	}
+}
+
+// AddUnit stores a unit, assigning it an id if it has none, and returns it
+func (m *Memory) AddUnit(unit models.Unit) models.Unit {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if unit.ID == 0 {
+		m.nextUnitID++
+		unit.ID = m.nextUnitID
+	} else if unit.ID > m.nextUnitID {
+		m.nextUnitID = unit.ID
+	}
+	m.units[unit.ID] = unit
+	return unit
+}
+
+// AddPrefecture stores a prefecture, assigning it the next id, and returns
+// it
+func (m *Memory) AddPrefecture(pref models.Prefecture) models.Prefecture {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pref.ID = len(m.prefectures) + 1
+	m.prefectures = append(m.prefectures, pref)
+	return pref
+}
+
+// SetUser stores a user as is, e.g. to make them premium
+func (m *Memory) SetUser(user models.User) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[user.LineUserID] = user
+}
+
+// AddPlan stores a plan, replacing any with the same code
+func (m *Memory) AddPlan(plan models.Plan) models.Plan {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.plans {
+		if existing.Code == plan.Code {
+			plan.ID = existing.ID
+			m.plans[i] = plan
+			return plan
+		}
+	}
+	plan.ID = len(m.plans) + 1
+	m.plans = append(m.plans, plan)
+	return plan
+}
+
+// SetClock sets the clock the notification queue reads, e.g. to let claims
+// and retry delays run out
+func (m *Memory) SetClock(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
+
+// AddNotification queues an alert about a unit for delivery, like the memory
+// RoomStore does, and returns the queued notification
+func (m *Memory) AddNotification(unitID int, alert notify.Alert) (notify.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.newNotification(unitID, alert)
+	if err != nil {
+		return notify.Notification{}, err
+	}
+	m.enqueue(n)
+	return *n, nil
+}
+
+// Notifications returns the queued notifications in queue order
+func (m *Memory) Notifications() []notify.Notification {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notifications := make([]notify.Notification, 0, len(m.notifications))
+	for _, n := range m.notifications {
+		notifications = append(notifications, *n)
+	}
+	return notifications
+}
+
+// newNotification returns a pending notification for alert, to be queued
+// with enqueue. m.mu must be held.
+func (m *Memory) newNotification(unitID int, alert notify.Alert) (*notify.Notification, error) {
+	if len(alert.Messages) == 0 {
+		return nil, fmt.Errorf("no messages to enqueue")
+	}
+	messages, err := json.Marshal(alert.Messages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode messages: %w", err)
+	}
+
+	now := m.now()
+	n := &notify.Notification{
+		UserID:        alert.UserID,
+		UnitID:        unitID,
+		Messages:      messages,
+		Status:        notify.StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	if alert.SubscriptionID != 0 {
+		n.SubscriptionID = sql.NullInt64{Int64: int64(alert.SubscriptionID), Valid: true}
+	}
+	return n, nil
+}
+
+// enqueue assigns n the next id and queues it. m.mu must be held.
+func (m *Memory) enqueue(n *notify.Notification) {
+	n.ID = len(m.notifications) + 1
+	m.notifications = append(m.notifications, n)
+}
+
+// SetLastCheck records the latest room check of a unit
+func (m *Memory) SetLastCheck(unitID int, check models.UnitCheck) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checks[unitID] = check
+}
+
+// Alerts returns the alerts queued so far, oldest first
+func (m *Memory) Alerts() []QueuedAlert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]QueuedAlert(nil), m.alerts...)
+}
+
+// Cursor returns the progress of a shard
+func (m *Memory) Cursor(shard checkcursor.Shard) Cursor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cursors[shard]
+}
+
+type memoryUsers struct{ m *Memory }
+
+func (s memoryUsers) Get(ctx context.Context, lineUserID string) (*models.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	user, ok := s.m.users[lineUserID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s memoryUsers) Search(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var users []models.User
+	for _, user := range s.m.users {
+		if strings.Contains(strings.ToLower(user.LineUserID), strings.ToLower(query)) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.After(users[j].CreatedAt)
+		}
+		return users[i].LineUserID > users[j].LineUserID
+	})
+	return page(users, limit, offset), nil
+}
+
+func (s memoryUsers) Ensure(ctx context.Context, lineUserID string) (*models.User, error) {
+	s.m.mu.Lock()
+	if _, ok := s.m.users[lineUserID]; !ok {
+		s.m.users[lineUserID] = models.User{LineUserID: lineUserID, CreatedAt: time.Now()}
+	}
+	s.m.mu.Unlock()
+	return s.Get(ctx, lineUserID)
+}
+
+func (s memoryUsers) Follow(ctx context.Context, lineUserID, replyToken string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	user, ok := s.m.users[lineUserID]
+	if !ok {
+		user = models.User{LineUserID: lineUserID, CreatedAt: time.Now()}
+	}
+	user.ReplyToken = replyToken
+	s.m.users[lineUserID] = user
+	return nil
+}
+
+func (s memoryUsers) SetReplyToken(ctx context.Context, lineUserID, replyToken string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if user, ok := s.m.users[lineUserID]; ok {
+		user.ReplyToken = replyToken
+		s.m.users[lineUserID] = user
+	}
+	return nil
+}
+
+// Delete removes the user and, like the foreign keys in Postgres, their
+// subscriptions and plan assignment
+func (s memoryUsers) Delete(ctx context.Context, lineUserID string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	delete(s.m.users, lineUserID)
+	delete(s.m.userPlans, lineUserID)
+	s.m.deleteSubscriptions(lineUserID)
+	return nil
+}
+
+type memoryUnits struct{ m *Memory }
+
+func (s memoryUnits) List(ctx context.Context) ([]models.Unit, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	units := make([]models.Unit, 0, len(s.m.units))
+	for _, unit := range s.m.units {
+		if !unit.Delisted {
+			units = append(units, unit)
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+	return units, nil
+}
+
+func (s memoryUnits) Get(ctx context.Context, id int) (*models.Unit, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	unit, ok := s.m.units[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &unit, nil
+}
+
+func (s memoryUnits) Match(ctx context.Context, name string, limit int) ([]models.Unit, error) {
+	units, _ := s.List(ctx)
+	normalized := unitsearch.Normalize(name)
+	if normalized == "" {
+		return nil, nil
+	}
+
+	var exact []models.Unit
+	candidates := make([]unitsearch.Candidate, 0, len(units))
+	byID := make(map[int]models.Unit, len(units))
+	for _, unit := range units {
+		if unitsearch.Normalize(unit.Name) == normalized {
+			exact = append(exact, unit)
+		}
+		candidates = append(candidates, unitsearch.Candidate{ID: unit.ID, Name: unit.Name})
+		byID[unit.ID] = unit
+	}
+	if len(exact) > 0 {
+		return exact, nil
+	}
+
+	var similar []models.Unit
+	for _, match := range unitsearch.Rank(name, candidates) {
+		if len(similar) == limit {
+			break
+		}
+		similar = append(similar, byID[match.ID])
+	}
+	return similar, nil
+}
+
+func (s memoryUnits) Search(ctx context.Context, query string, limit, offset int) ([]models.UnitSummary, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	query = strings.ToLower(query)
+	var units []models.UnitSummary
+	for _, unit := range s.m.units {
+		if !strings.Contains(strings.ToLower(unit.Name), query) && !strings.Contains(strings.ToLower(unit.Code.String()), query) {
+			continue
+		}
+		summary := models.UnitSummary{Unit: unit}
+		for _, sub := range s.m.subscriptions {
+			if !sub.deleted && sub.UnitID == unit.ID {
+				summary.Subscribers++
+			}
+		}
+		units = append(units, summary)
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+	return page(units, limit, offset), nil
+}
+
+func (s memoryUnits) ListSubscribed(ctx context.Context, shardIndex, shardCount, after int) ([]models.Unit, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := time.Now()
+	seen := make(map[int]bool)
+	var units []models.Unit
+	for _, sub := range s.m.subscriptions {
+		id := sub.UnitID
+		if sub.deleted || seen[id] || id <= after || id%shardCount != shardIndex {
+			continue
+		}
+		if _, ok := s.m.users[sub.LineUserID]; !ok {
+			continue
+		}
+		if unit, ok := s.m.units[id]; ok && s.m.due(id, now) {
+			seen[id] = true
+			units = append(units, unit)
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+	return units, nil
+}
+
+func (s memoryUnits) LastCheck(ctx context.Context, unitID int) (*models.UnitCheck, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	check, ok := s.m.checks[unitID]
+	if !ok {
+		return nil, nil
+	}
+	return &check, nil
+}
+
+func (s memoryUnits) Upsert(ctx context.Context, unit models.Unit) (bool, bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var existing *models.Unit
+	for id, u := range s.m.units {
+		switch {
+		case u.Code == unit.Code:
+			existing = &u
+		case u.Name == unit.Name:
+			return false, false, fmt.Errorf("another unit already has this name")
+		default:
+			continue
+		}
+		unit.ID = id
+	}
+	unit.Delisted = false
+
+	if existing == nil {
+		s.m.nextUnitID++
+		unit.ID = s.m.nextUnitID
+		s.m.units[unit.ID] = unit
+		return true, false, nil
+	}
+	if *existing == unit {
+		return false, false, nil
+	}
+	s.m.units[unit.ID] = unit
+	return false, true, nil
+}
+
+func (s memoryUnits) Delist(ctx context.Context, skcID int, codes []models.UnitCode) ([]models.Unit, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	listed := make(map[models.UnitCode]bool, len(codes))
+	for _, code := range codes {
+		listed[code] = true
+	}
+	var delisted []models.Unit
+	for id, unit := range s.m.units {
+		if unit.SKCID == skcID && !unit.Delisted && !listed[unit.Code] {
+			unit.Delisted = true
+			s.m.units[id] = unit
+			delisted = append(delisted, unit)
+		}
+	}
+	sort.Slice(delisted, func(i, j int) bool { return delisted[i].ID < delisted[j].ID })
+	return delisted, nil
+}
+
+type memoryCatalog struct{ m *Memory }
+
+func (s memoryCatalog) Prefectures(ctx context.Context, urCodes []string) ([]models.Prefecture, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var prefs []models.Prefecture
+	for _, pref := range s.m.prefectures {
+		if len(urCodes) == 0 || slices.Contains(urCodes, pref.URCode) {
+			prefs = append(prefs, pref)
+		}
+	}
+	sort.Slice(prefs, func(i, j int) bool { return prefs[i].URCode < prefs[j].URCode })
+	return prefs, nil
+}
+
+func (s memoryCatalog) SaveArea(ctx context.Context, prefectureID int, area models.Area) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	key := memoryArea{prefectureID: prefectureID, code: area.Code}
+	if id, ok := s.m.areas[key]; ok {
+		return id, nil
+	}
+	id := len(s.m.areas) + 1
+	s.m.areas[key] = id
+	return id, nil
+}
+
+func (s memoryCatalog) SaveSKC(ctx context.Context, areaID int, skc models.SKC) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	key := memorySKC{areaID: areaID, urID: skc.URID}
+	if id, ok := s.m.skcs[key]; ok {
+		return id, nil
+	}
+	id := len(s.m.skcs) + 1
+	s.m.skcs[key] = id
+	return id, nil
+}
+
+type memorySubscriptions struct{ m *Memory }
+
+func (s memorySubscriptions) CountActive(ctx context.Context, lineUserID string) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	count := 0
+	for _, sub := range s.m.subscriptions {
+		if !sub.deleted && sub.LineUserID == lineUserID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s memorySubscriptions) Save(ctx context.Context, sub models.Subscription) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.saveSubscription(sub)
+	return nil
+}
+
+func (s memorySubscriptions) Subscribe(ctx context.Context, sub models.Subscription) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if _, ok := s.m.users[sub.LineUserID]; !ok {
+		s.m.users[sub.LineUserID] = models.User{LineUserID: sub.LineUserID, CreatedAt: time.Now()}
+	}
+
+	plan, err := s.m.activePlan(sub.LineUserID)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, existing := range s.m.subscriptions {
+		if !existing.deleted && existing.LineUserID == sub.LineUserID && existing.UnitID != sub.UnitID {
+			count++
+		}
+	}
+	if err := plan.Plan.Permits(sub, count); err != nil {
+		return err
+	}
+
+	s.m.saveSubscription(sub)
+	return nil
+}
+
+// saveSubscription upserts sub on the user and unit pair. m.mu must be held.
+func (m *Memory) saveSubscription(sub models.Subscription) {
+	if sub.Mode == "" {
+		sub.Mode = models.SubscriptionModeOneShot
+	}
+	sub.UnitName = ""
+
+	for _, existing := range m.subscriptions {
+		if existing.LineUserID == sub.LineUserID && existing.UnitID == sub.UnitID {
+			sub.ID = existing.ID
+			sub.CreatedAt = existing.CreatedAt
+			existing.Subscription = sub
+			existing.deleted = false
+			return
+		}
+	}
+
+	m.nextSubID++
+	sub.ID = m.nextSubID
+	sub.CreatedAt = time.Now()
+	m.subscriptions = append(m.subscriptions, &memorySubscription{Subscription: sub})
+}
+
+func (s memorySubscriptions) Cancel(ctx context.Context, lineUserID string, unitID int) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, sub := range s.m.subscriptions {
+		if !sub.deleted && sub.LineUserID == lineUserID && sub.UnitID == unitID {
+			sub.deleted = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s memorySubscriptions) CancelAll(ctx context.Context, lineUserID string) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	count := 0
+	for _, sub := range s.m.subscriptions {
+		if !sub.deleted && sub.LineUserID == lineUserID {
+			sub.deleted = true
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s memorySubscriptions) DeleteAll(ctx context.Context, lineUserID string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.m.deleteSubscriptions(lineUserID)
+	return nil
+}
+
+func (s memorySubscriptions) ListByUser(ctx context.Context, lineUserID string) ([]models.Subscription, error) {
+	return s.list(func(sub *memorySubscription) bool { return sub.LineUserID == lineUserID }), nil
+}
+
+func (s memorySubscriptions) ListByUnit(ctx context.Context, unitID int) ([]models.Subscription, error) {
+	return s.list(func(sub *memorySubscription) bool {
+		_, ok := s.m.users[sub.LineUserID]
+		return ok && sub.UnitID == unitID
+	}), nil
+}
+
+// list returns copies of the active subscriptions matching keep, in the
+// order they were created, with unit names filled in
+func (s memorySubscriptions) list(keep func(*memorySubscription) bool) []models.Subscription {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var subs []models.Subscription
+	for _, sub := range s.m.subscriptions {
+		unit, ok := s.m.units[sub.UnitID]
+		if sub.deleted || !ok || !keep(sub) {
+			continue
+		}
+		copied := sub.Subscription
+		copied.UnitName = unit.Name
+		copied.RoomTypes = append([]string(nil), sub.RoomTypes...)
+		subs = append(subs, copied)
+	}
+	return subs
+}
+
+// due reports whether a unit should be checked, following the same rule as
+// the Postgres store: the most frequent plan among its subscribers decides,
+// with a minute of slack. m.mu must be held.
+func (m *Memory) due(unitID int, now time.Time) bool {
+	check, ok := m.checks[unitID]
+	if !ok {
+		return true
+	}
+
+	interval := time.Duration(-1)
+	for _, sub := range m.subscriptions {
+		if sub.deleted || sub.UnitID != unitID {
+			continue
+		}
+		if _, ok := m.users[sub.LineUserID]; !ok {
+			continue
+		}
+		plan, err := m.activePlan(sub.LineUserID)
+		if err != nil {
+			return true
+		}
+		if interval < 0 || plan.Plan.CheckInterval < interval {
+			interval = plan.Plan.CheckInterval
+		}
+	}
+	return !check.CheckedAt.After(now.Add(-interval + time.Minute))
+}
+
+// activePlan returns the user's unexpired assignment, or the default plan.
+// m.mu must be held.
+func (m *Memory) activePlan(lineUserID string) (*models.UserPlan, error) {
+	if userPlan, ok := m.userPlans[lineUserID]; ok {
+		if userPlan.ExpiresAt == nil || userPlan.ExpiresAt.After(time.Now()) {
+			return &userPlan, nil
+		}
+	}
+	for _, plan := range m.plans {
+		if plan.IsDefault {
+			return &models.UserPlan{LineUserID: lineUserID, Plan: plan}, nil
+		}
+	}
+	return nil, fmt.Errorf("user %s has no plan and there is no default plan", lineUserID)
+}
+
+type memoryPlans struct{ m *Memory }
+
+func (s memoryPlans) List(ctx context.Context) ([]models.Plan, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return append([]models.Plan(nil), s.m.plans...), nil
+}
+
+func (s memoryPlans) Get(ctx context.Context, code string) (*models.Plan, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, plan := range s.m.plans {
+		if plan.Code == code {
+			return &plan, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s memoryPlans) Active(ctx context.Context, lineUserID string) (*models.UserPlan, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return s.m.activePlan(lineUserID)
+}
+
+func (s memoryPlans) Assign(ctx context.Context, lineUserID, planCode string, expiresAt *time.Time, changedBy, reason string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if _, ok := s.m.users[lineUserID]; !ok {
+		return ErrNotFound
+	}
+
+	var plan *models.Plan
+	for i := range s.m.plans {
+		if s.m.plans[i].Code == planCode {
+			plan = &s.m.plans[i]
+		}
+	}
+	if plan == nil {
+		return ErrNotFound
+	}
+
+	change := models.PlanChange{
+		ID:          len(s.m.planChanges) + 1,
+		LineUserID:  lineUserID,
+		NewPlanCode: planCode,
+		ExpiresAt:   expiresAt,
+		ChangedBy:   changedBy,
+		Reason:      reason,
+		CreatedAt:   time.Now(),
+	}
+	if old, ok := s.m.userPlans[lineUserID]; ok {
+		change.OldPlanCode = old.Plan.Code
+	}
+
+	s.m.userPlans[lineUserID] = models.UserPlan{LineUserID: lineUserID, Plan: *plan, ExpiresAt: expiresAt}
+	s.m.planChanges = append(s.m.planChanges, change)
+	return nil
+}
+
+func (s memoryPlans) History(ctx context.Context, lineUserID string) ([]models.PlanChange, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var changes []models.PlanChange
+	for _, change := range s.m.planChanges {
+		if change.LineUserID == lineUserID {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+type memoryRooms struct{ m *Memory }
+
+// Record diffs rooms against the previous ones, saves them as the unit's
+// last check and queues the alerts, all under the store's lock
+func (s memoryRooms) Record(ctx context.Context, unitID int, rooms []urclient.Room, alerts func(snapshot.Changes) []notify.Alert) (snapshot.Changes, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if _, ok := s.m.units[unitID]; !ok {
+		return snapshot.Changes{}, ErrNotFound
+	}
+
+	changes := snapshot.Diff(s.m.rooms[unitID], rooms)
+	queued := alerts(changes)
+	notifications := make([]*notify.Notification, 0, len(queued))
+	for _, alert := range queued {
+		n, err := s.m.newNotification(unitID, alert)
+		if err != nil {
+			return snapshot.Changes{}, fmt.Errorf("failed to queue alert for user %s: %w", alert.UserID, err)
+		}
+		notifications = append(notifications, n)
+	}
+	for i, alert := range queued {
+		s.m.alerts = append(s.m.alerts, QueuedAlert{UnitID: unitID, Alert: alert})
+		s.m.enqueue(notifications[i])
+	}
+	s.m.rooms[unitID] = append([]urclient.Room(nil), rooms...)
+	s.m.checks[unitID] = models.UnitCheck{CheckedAt: time.Now(), RoomCount: len(rooms)}
+	return changes, nil
+}
+
+type memoryNotifications struct{ m *Memory }
+
+func (s memoryNotifications) Claim(ctx context.Context, limit int, lease time.Duration) ([]notify.Notification, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := s.m.now()
+	var batch []notify.Notification
+	for _, n := range s.m.notifications {
+		if len(batch) == limit {
+			break
+		}
+		due := n.Status == notify.StatusPending && !n.NextAttemptAt.After(now)
+		expired := n.Status == notify.StatusSending && n.ClaimedUntil.Time.Before(now)
+		if !due && !expired {
+			continue
+		}
+
+		n.Status = notify.StatusSending
+		n.Attempts++
+		n.ClaimedUntil = sql.NullTime{Time: now.Add(lease), Valid: true}
+		batch = append(batch, *n)
+	}
+	return batch, nil
+}
+
+func (s memoryNotifications) Record(ctx context.Context, outcomes []notify.Outcome) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, outcome := range outcomes {
+		switch outcome.Status {
+		case notify.StatusSent, notify.StatusFailed, notify.StatusPending:
+		default:
+			return fmt.Errorf("notification %d has no outcome status %q", outcome.ID, outcome.Status)
+		}
+	}
+
+	now := s.m.now()
+	for _, outcome := range outcomes {
+		if outcome.Status == notify.StatusSent && outcome.SubscriptionID.Valid {
+			for _, sub := range s.m.subscriptions {
+				if int64(sub.ID) == outcome.SubscriptionID.Int64 && sub.Mode == models.SubscriptionModeOneShot {
+					sub.deleted = true
+				}
+			}
+		}
+
+		if outcome.ID < 1 || outcome.ID > len(s.m.notifications) {
+			continue
+		}
+		n := s.m.notifications[outcome.ID-1]
+		if n.Status != notify.StatusSending {
+			continue
+		}
+
+		n.Status = outcome.Status
+		n.ClaimedUntil = sql.NullTime{}
+		switch outcome.Status {
+		case notify.StatusSent:
+			n.SentAt = sql.NullTime{Time: now, Valid: true}
+			n.LastError = sql.NullString{}
+		case notify.StatusFailed:
+			n.LastError = sql.NullString{String: outcome.Error, Valid: true}
+		case notify.StatusPending:
+			n.LastError = sql.NullString{String: outcome.Error, Valid: true}
+			n.NextAttemptAt = now.Add(outcome.RetryAfter)
+		}
+	}
+	return nil
+}
+
+type memoryCursors struct{ m *Memory }
+
+func (s memoryCursors) Lock(ctx context.Context, shard checkcursor.Shard) (func(), error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if s.m.lockedShards[shard] {
+		return nil, checkcursor.ErrLocked
+	}
+	s.m.lockedShards[shard] = true
+	return func() {
+		s.m.mu.Lock()
+		defer s.m.mu.Unlock()
+		delete(s.m.lockedShards, shard)
+	}, nil
+}
+
+func (s memoryCursors) Load(ctx context.Context, shard checkcursor.Shard) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return s.m.cursors[shard].LastUnitID, nil
+}
+
+func (s memoryCursors) Advance(ctx context.Context, shard checkcursor.Shard, lastUnitID int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	cursor := s.m.cursors[shard]
+	cursor.LastUnitID = lastUnitID
+	s.m.cursors[shard] = cursor
+	return nil
+}
+
+func (s memoryCursors) Complete(ctx context.Context, shard checkcursor.Shard) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	cursor := s.m.cursors[shard]
+	cursor.LastUnitID = 0
+	cursor.CyclesCompleted++
+	s.m.cursors[shard] = cursor
+	return nil
+}
+
+// page returns the items from offset up to limit of them
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// deleteSubscriptions removes every subscription of the user. m.mu must be held.
+func (m *Memory) deleteSubscriptions(lineUserID string) {
+	kept := m.subscriptions[:0]
+	for _, sub := range m.subscriptions {
+		if sub.LineUserID != lineUserID {
+			kept = append(kept, sub)
+		}
+	}
+	m.subscriptions = kept
+}
+
+var (
+	_ UserStore         = memoryUsers{}
+	_ UnitStore         = memoryUnits{}
+	_ SubscriptionStore = memorySubscriptions{}
+	_ PlanStore         = memoryPlans{}
+	_ RoomStore         = memoryRooms{}
+	_ CursorStore       = memoryCursors{}
+	_ CatalogStore      = memoryCatalog{}
+)
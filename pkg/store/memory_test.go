@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// testResubscribeAtLimit subscribes a free user to as many units as the plan
+// allows, then changes the conditions of one of those subscriptions, which
+// must not count as another one. unitIDs needs one more unit than the limit.
+func testResubscribeAtLimit(t *testing.T, stores Stores, userID string, unitIDs []int) {
+	t.Helper()
+	ctx := context.Background()
+
+	free, err := stores.Plans.Get(ctx, "free")
+	if err != nil {
+		t.Fatalf("Plans.Get(free) error = %v", err)
+	}
+	limit := *free.MaxSubscriptions
+	if len(unitIDs) <= limit {
+		t.Fatalf("need %d units, got %d", limit+1, len(unitIDs))
+	}
+
+	for _, unitID := range unitIDs[:limit] {
+		if err := stores.Subscriptions.Subscribe(ctx, models.Subscription{LineUserID: userID, UnitID: unitID}); err != nil {
+			t.Fatalf("Subscribe(%d) error = %v", unitID, err)
+		}
+	}
+
+	maxRent := 150000
+	changed := models.Subscription{
+		LineUserID: userID,
+		UnitID:     unitIDs[0],
+		RoomTypes:  []string{"2LDK"},
+		Filters:    models.SubscriptionFilters{MaxRent: &maxRent},
+	}
+	if err := stores.Subscriptions.Subscribe(ctx, changed); err != nil {
+		t.Errorf("re-subscribing to unit %d at the limit: error = %v, want nil", unitIDs[0], err)
+	}
+
+	err = stores.Subscriptions.Subscribe(ctx, models.Subscription{LineUserID: userID, UnitID: unitIDs[limit]})
+	if !errors.Is(err, models.ErrSubscriptionLimit) {
+		t.Errorf("subscribing to another unit at the limit: error = %v, want ErrSubscriptionLimit", err)
+	}
+
+	count, err := stores.Subscriptions.CountActive(ctx, userID)
+	if err != nil {
+		t.Fatalf("CountActive() error = %v", err)
+	}
+	if count != limit {
+		t.Errorf("active subscriptions = %d, want %d", count, limit)
+	}
+}
+
+func TestMemorySubscribeReplacesSameUnitAtLimit(t *testing.T) {
+	memory := NewMemory()
+	first := memory.AddUnit(models.Unit{Name: "神田須田町"})
+	second := memory.AddUnit(models.Unit{Name: "小石川五丁目"})
+
+	testResubscribeAtLimit(t, memory.Stores(), "Utest", []int{first.ID, second.ID})
+}
+
+// testMatch checks that Match finds the exact unit despite width and spacing
+// differences, and otherwise falls back to similar names, most similar
+// first. The names in matchNames followed by suffix must have been added as
+// units; other units are ignored.
+func testMatch(t *testing.T, units UnitStore, suffix string) {
+	t.Helper()
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"exact", "東雲キャナルコートCODAN" + suffix, "東雲キャナルコートCODAN"},
+		{"full-width and spaced", "東雲 キャナルコート ＣＯＤＡＮ" + suffix, "東雲キャナルコートCODAN"},
+		{"half-width katakana", "ﾋﾊﾞﾘｶﾞ丘ﾊﾟｰｸﾋﾙｽﾞ" + suffix, "ひばりが丘パークヒルズ"},
+		{"partial, closest first", "光が丘パークタウン大通", "光が丘パークタウン大通り中央"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := units.Match(ctx, tt.query, 5)
+			if err != nil {
+				t.Fatalf("Match() error = %v", err)
+			}
+			var got []string
+			for _, unit := range matched {
+				if name, ok := strings.CutSuffix(unit.Name, suffix); ok && slices.Contains(matchNames, name) {
+					got = append(got, name)
+				}
+			}
+			if len(got) == 0 || got[0] != tt.want {
+				t.Errorf("Match(%q) = %v, want %s first", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+var matchNames = []string{
+	"東雲キャナルコートCODAN",
+	"ひばりが丘パークヒルズ",
+	"光が丘パークタウン大通り中央",
+	"光が丘パークタウンいちょう通り八番街",
+	"大島四丁目",
+}
+
+func TestMemoryMatch(t *testing.T) {
+	memory := NewMemory()
+	for _, name := range matchNames {
+		memory.AddUnit(models.Unit{Name: name})
+	}
+
+	testMatch(t, memory.Stores().Units, "")
+}
@@ -0,0 +1,554 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/unitsearch"
+)
+
+// NewPostgres returns stores backed by the given database
+func NewPostgres(db *sql.DB) Stores {
+	return Stores{
+		Users:         &PostgresUserStore{DB: db},
+		Units:         &PostgresUnitStore{DB: db},
+		Subscriptions: &PostgresSubscriptionStore{DB: db},
+		Plans:         &PostgresPlanStore{DB: db},
+		Rooms:         &PostgresRoomStore{DB: db},
+		Cursors:       &PostgresCursorStore{DB: db},
+		Catalog:       &PostgresCatalogStore{DB: db},
+		Notifications: &PostgresNotificationStore{DB: db},
+	}
+}
+
+// PostgresUserStore is a UserStore on the users table
+type PostgresUserStore struct {
+	DB *sql.DB
+}
+
+func (s *PostgresUserStore) Get(ctx context.Context, lineUserID string) (*models.User, error) {
+	var user models.User
+	var replyToken sql.NullString
+	var createdAt sql.NullTime
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT line_user_id, reply_token, created_at
+		FROM users
+		WHERE line_user_id = $1`, lineUserID).Scan(&user.LineUserID, &replyToken, &createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query user: %w", err)
+	}
+
+	user.ReplyToken = replyToken.String
+	user.CreatedAt = createdAt.Time
+	return &user, nil
+}
+
+func (s *PostgresUserStore) Search(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT line_user_id, COALESCE(reply_token, ''), created_at
+		FROM users
+		WHERE line_user_id ILIKE $1 ESCAPE '\'
+		ORDER BY created_at DESC NULLS LAST, id DESC
+		LIMIT $2 OFFSET $3
+	`, containsPattern(query), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var createdAt sql.NullTime
+		if err := rows.Scan(&user.LineUserID, &user.ReplyToken, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		user.CreatedAt = createdAt.Time
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+	return users, nil
+}
+
+func (s *PostgresUserStore) Ensure(ctx context.Context, lineUserID string) (*models.User, error) {
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO users (line_user_id) VALUES ($1) ON CONFLICT (line_user_id) DO NOTHING", lineUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return s.Get(ctx, lineUserID)
+}
+
+func (s *PostgresUserStore) Follow(ctx context.Context, lineUserID, replyToken string) error {
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO users (line_user_id, reply_token) VALUES ($1, $2) ON CONFLICT (line_user_id) DO UPDATE SET reply_token = $2",
+		lineUserID, replyToken)
+	if err != nil {
+		return fmt.Errorf("failed to save user: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresUserStore) SetReplyToken(ctx context.Context, lineUserID, replyToken string) error {
+	_, err := s.DB.ExecContext(ctx, "UPDATE users SET reply_token = $1 WHERE line_user_id = $2", replyToken, lineUserID)
+	if err != nil {
+		return fmt.Errorf("failed to update reply token: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresUserStore) Delete(ctx context.Context, lineUserID string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM users WHERE line_user_id = $1", lineUserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+// PostgresUnitStore is a UnitStore on the units table
+type PostgresUnitStore struct {
+	DB *sql.DB
+}
+
+const unitColumns = `u.id, u.unit_name, u.unit_code, COALESCE(u.url, ''), COALESCE(u.image, ''),
+	COALESCE(u.rent, ''), COALESCE(u.common_fee, ''), COALESCE(u.skc_id, 0), u.delisted_at IS NOT NULL`
+
+func scanUnit(row interface{ Scan(...any) error }, extra ...any) (models.Unit, error) {
+	var unit models.Unit
+	dest := []any{&unit.ID, &unit.Name, &unit.Code, &unit.URL, &unit.Image, &unit.Rent, &unit.CommonFee, &unit.SKCID, &unit.Delisted}
+	err := row.Scan(append(dest, extra...)...)
+	return unit, err
+}
+
+func (s *PostgresUnitStore) List(ctx context.Context) ([]models.Unit, error) {
+	return s.query(ctx, "SELECT "+unitColumns+" FROM units u WHERE u.delisted_at IS NULL ORDER BY u.id")
+}
+
+func (s *PostgresUnitStore) Get(ctx context.Context, id int) (*models.Unit, error) {
+	unit, err := scanUnit(s.DB.QueryRowContext(ctx, "SELECT "+unitColumns+" FROM units u WHERE u.id = $1", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query unit: %w", err)
+	}
+	return &unit, nil
+}
+
+// searchNameBackfill is how many units without a search_name a lookup
+// fills in before matching
+const searchNameBackfill = 500
+
+// Match looks the normalized name up in units.search_name, then falls back
+// to trigram similarity and substring candidates, both served by
+// idx_units_search_name_trgm
+func (s *PostgresUnitStore) Match(ctx context.Context, name string, limit int) ([]models.Unit, error) {
+	normalized := unitsearch.Normalize(name)
+	if normalized == "" {
+		return nil, nil
+	}
+	if err := s.fillSearchNames(ctx); err != nil {
+		return nil, err
+	}
+
+	units, err := s.query(ctx, "SELECT "+unitColumns+" FROM units u WHERE u.search_name = $1 AND u.delisted_at IS NULL ORDER BY u.id", normalized)
+	if err != nil || len(units) > 0 {
+		return units, err
+	}
+
+	return s.query(ctx, `
+		SELECT `+unitColumns+`
+		FROM units u
+		WHERE u.delisted_at IS NULL
+			AND (u.search_name % $1 OR u.search_name LIKE $2 ESCAPE '\')
+		ORDER BY similarity(u.search_name, $1) DESC, u.id
+		LIMIT $3`, normalized, containsPattern(normalized), limit)
+}
+
+// fillSearchNames sets search_name on units that have none, e.g. ones
+// inserted before the column existed, a bounded number at a time
+func (s *PostgresUnitStore) fillSearchNames(ctx context.Context) error {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, unit_name FROM units WHERE search_name IS NULL LIMIT $1", searchNameBackfill)
+	if err != nil {
+		return fmt.Errorf("failed to query units without search names: %w", err)
+	}
+	var ids []int64
+	var names []string
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan unit: %w", err)
+		}
+		ids = append(ids, id)
+		names = append(names, unitsearch.Normalize(name))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read units without search names: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE units u SET search_name = v.search_name
+		FROM unnest($1::int[], $2::text[]) AS v(id, search_name)
+		WHERE u.id = v.id`, pq.Array(ids), pq.Array(names))
+	if err != nil {
+		return fmt.Errorf("failed to fill search names: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresUnitStore) Search(ctx context.Context, query string, limit, offset int) ([]models.UnitSummary, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+unitColumns+`, COUNT(s.id)
+		FROM units u
+		LEFT JOIN subscriptions s ON s.unit_id = u.id AND s.deleted_at IS NULL
+		WHERE u.unit_name ILIKE $1 ESCAPE '\' OR u.unit_code ILIKE $1 ESCAPE '\'
+		GROUP BY u.id
+		ORDER BY u.id
+		LIMIT $2 OFFSET $3
+	`, containsPattern(query), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query units: %w", err)
+	}
+	defer rows.Close()
+
+	var units []models.UnitSummary
+	for rows.Next() {
+		var subscribers int
+		unit, err := scanUnit(rows, &subscribers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan unit: %w", err)
+		}
+		units = append(units, models.UnitSummary{Unit: unit, Subscribers: subscribers})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read units: %w", err)
+	}
+	return units, nil
+}
+
+// ListSubscribed only returns units that are due for a check: a unit is
+// checked as often as the most frequent plan among its subscribers allows.
+// A minute of slack keeps a unit checked at the end of one scheduled run due
+// at the start of the next.
+func (s *PostgresUnitStore) ListSubscribed(ctx context.Context, shardIndex, shardCount, after int) ([]models.Unit, error) {
+	return s.query(ctx, `
+		WITH intervals AS (
+			SELECT s.unit_id, MIN(COALESCE(ap.check_interval_minutes, dp.check_interval_minutes, 0)) AS minutes
+			FROM subscriptions s
+			JOIN users usr ON s.line_user_id = usr.line_user_id
+			LEFT JOIN user_plans up ON up.line_user_id = s.line_user_id
+				AND (up.expires_at IS NULL OR up.expires_at > NOW())
+			LEFT JOIN plans ap ON ap.id = up.plan_id
+			LEFT JOIN plans dp ON dp.is_default
+			WHERE s.deleted_at IS NULL
+			GROUP BY s.unit_id
+		)
+		SELECT `+unitColumns+`
+		FROM units u
+		JOIN intervals i ON i.unit_id = u.id
+		LEFT JOIN LATERAL (
+			SELECT checked_at
+			FROM room_snapshots
+			WHERE unit_id = u.id
+			ORDER BY id DESC
+			LIMIT 1
+		) rs ON TRUE
+		WHERE u.id % $1 = $2 AND u.id > $3
+			AND (rs.checked_at IS NULL
+				OR rs.checked_at <= NOW() - make_interval(mins => i.minutes) + INTERVAL '1 minute')
+		ORDER BY u.id
+	`, shardCount, shardIndex, after)
+}
+
+func (s *PostgresUnitStore) LastCheck(ctx context.Context, unitID int) (*models.UnitCheck, error) {
+	var check models.UnitCheck
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT checked_at, room_count
+		FROM room_snapshots
+		WHERE unit_id = $1
+		ORDER BY id DESC
+		LIMIT 1`, unitID).Scan(&check.CheckedAt, &check.RoomCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query last check: %w", err)
+	}
+	return &check, nil
+}
+
+// Upsert relies on the unique unit_code: xmax is 0 only for a row the
+// statement inserted, and the WHERE leaves up-to-date rows untouched so that
+// they return nothing
+func (s *PostgresUnitStore) Upsert(ctx context.Context, unit models.Unit) (bool, bool, error) {
+	var inserted bool
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO units (unit_name, unit_code, url, image, rent, common_fee, skc_id, search_name)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0), $8)
+		ON CONFLICT (unit_code) DO UPDATE
+		SET unit_name = EXCLUDED.unit_name, url = EXCLUDED.url, image = EXCLUDED.image, rent = EXCLUDED.rent,
+			common_fee = EXCLUDED.common_fee, skc_id = EXCLUDED.skc_id, search_name = EXCLUDED.search_name, delisted_at = NULL
+		WHERE units.delisted_at IS NOT NULL
+			OR (units.unit_name, units.url, units.image, units.rent, units.common_fee, units.skc_id, units.search_name)
+				IS DISTINCT FROM (EXCLUDED.unit_name, EXCLUDED.url, EXCLUDED.image, EXCLUDED.rent, EXCLUDED.common_fee, EXCLUDED.skc_id, EXCLUDED.search_name)
+		RETURNING (xmax = 0)`,
+		unit.Name, unit.Code, unit.URL, unit.Image, unit.Rent, unit.CommonFee, unit.SKCID, unitsearch.Normalize(unit.Name)).Scan(&inserted)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, false, nil
+	case err != nil:
+		return false, false, unitWriteError(err)
+	}
+	return inserted, !inserted, nil
+}
+
+// unitWriteError explains the unique constraint on unit names, which UR
+// does not guarantee across prefectures
+func unitWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "units_unit_name_key" {
+		return fmt.Errorf("another unit already has this name")
+	}
+	return fmt.Errorf("failed to save unit: %w", err)
+}
+
+func (s *PostgresUnitStore) Delist(ctx context.Context, skcID int, codes []models.UnitCode) ([]models.Unit, error) {
+	listed := make([]string, 0, len(codes))
+	for _, code := range codes {
+		listed = append(listed, code.String())
+	}
+	return s.query(ctx, `
+		UPDATE units u SET delisted_at = NOW()
+		WHERE u.skc_id = $1 AND u.delisted_at IS NULL AND NOT (u.unit_code = ANY($2))
+		RETURNING `+unitColumns, skcID, pq.Array(listed))
+}
+
+func (s *PostgresUnitStore) query(ctx context.Context, query string, args ...any) ([]models.Unit, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query units: %w", err)
+	}
+	defer rows.Close()
+
+	var units []models.Unit
+	for rows.Next() {
+		unit, err := scanUnit(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan unit: %w", err)
+		}
+		units = append(units, unit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read units: %w", err)
+	}
+	return units, nil
+}
+
+// PostgresSubscriptionStore is a SubscriptionStore on the subscriptions table
+type PostgresSubscriptionStore struct {
+	DB *sql.DB
+}
+
+func (s *PostgresSubscriptionStore) CountActive(ctx context.Context, lineUserID string) (int, error) {
+	var count int
+	err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM subscriptions WHERE line_user_id = $1 AND deleted_at IS NULL", lineUserID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count subscriptions: %w", err)
+	}
+	return count, nil
+}
+
+func (s *PostgresSubscriptionStore) Save(ctx context.Context, sub models.Subscription) error {
+	return saveSubscription(ctx, s.DB, sub)
+}
+
+func (s *PostgresSubscriptionStore) Subscribe(ctx context.Context, sub models.Subscription) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user's row so that concurrent subscribes and plan changes for
+	// the same user queue up behind this one and see its subscription
+	if err := lockUser(ctx, tx, sub.LineUserID, true); err != nil {
+		return err
+	}
+
+	plan, err := activePlan(ctx, tx, sub.LineUserID)
+	if err != nil {
+		return err
+	}
+
+	// A subscription to the same unit is replaced rather than added, so it
+	// does not count against the limit
+	var count int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM subscriptions WHERE line_user_id = $1 AND unit_id <> $2 AND deleted_at IS NULL",
+		sub.LineUserID, sub.UnitID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to count subscriptions: %w", err)
+	}
+	if err := plan.Plan.Permits(sub, count); err != nil {
+		return err
+	}
+
+	if err := saveSubscription(ctx, tx, sub); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit subscription: %w", err)
+	}
+	return nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// saveSubscription upserts sub on the user and unit pair
+func saveSubscription(ctx context.Context, db execer, sub models.Subscription) error {
+	roomTypesJSON, err := json.Marshal(sub.RoomTypes)
+	if err != nil {
+		return err
+	}
+
+	mode := sub.Mode
+	if mode == "" {
+		mode = models.SubscriptionModeOneShot
+	}
+
+	filters := sub.Filters
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO subscriptions (line_user_id, unit_id, room_types, mode, max_rent, min_floor_area, min_floor, max_floor, deleted_at) 
+		VALUES ($1::text, $2, $3, $4, $5, $6, $7, $8, NULL) 
+		ON CONFLICT (line_user_id, unit_id) 
+		DO UPDATE SET room_types = $3, mode = $4, max_rent = $5, min_floor_area = $6, min_floor = $7, max_floor = $8, deleted_at = NULL`,
+		sub.LineUserID, sub.UnitID, roomTypesJSON, mode, filters.MaxRent, filters.MinFloorArea, filters.MinFloor, filters.MaxFloor)
+	if err != nil {
+		return fmt.Errorf("failed to save subscription: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresSubscriptionStore) Cancel(ctx context.Context, lineUserID string, unitID int) (bool, error) {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE subscriptions 
+		SET deleted_at = NOW() 
+		WHERE line_user_id = $1 AND unit_id = $2 AND deleted_at IS NULL`,
+		lineUserID, unitID)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel subscription: %w", err)
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+func (s *PostgresSubscriptionStore) CancelAll(ctx context.Context, lineUserID string) (int, error) {
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE subscriptions 
+		SET deleted_at = NOW() 
+		WHERE line_user_id = $1 AND deleted_at IS NULL`, lineUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel subscriptions: %w", err)
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+func (s *PostgresSubscriptionStore) DeleteAll(ctx context.Context, lineUserID string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM subscriptions WHERE line_user_id = $1", lineUserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete subscriptions: %w", err)
+	}
+	return nil
+}
+
+const subscriptionColumns = `s.id, s.line_user_id, s.unit_id, u.unit_name, s.room_types, s.mode,
+	s.max_rent, s.min_floor_area, s.min_floor, s.max_floor, s.created_at`
+
+func (s *PostgresSubscriptionStore) ListByUser(ctx context.Context, lineUserID string) ([]models.Subscription, error) {
+	return s.query(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions s
+		JOIN units u ON s.unit_id = u.id
+		WHERE s.line_user_id = $1 AND s.deleted_at IS NULL
+		ORDER BY s.created_at
+	`, lineUserID)
+}
+
+func (s *PostgresSubscriptionStore) ListByUnit(ctx context.Context, unitID int) ([]models.Subscription, error) {
+	return s.query(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions s
+		JOIN units u ON s.unit_id = u.id
+		JOIN users usr ON usr.line_user_id = s.line_user_id
+		WHERE s.unit_id = $1 AND s.deleted_at IS NULL
+		ORDER BY s.id
+	`, unitID)
+}
+
+func (s *PostgresSubscriptionStore) query(ctx context.Context, query string, args ...any) ([]models.Subscription, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []models.Subscription
+	for rows.Next() {
+		var sub models.Subscription
+		var roomTypesJSON []byte
+		var mode sql.NullString
+		var createdAt sql.NullTime
+		if err := rows.Scan(&sub.ID, &sub.LineUserID, &sub.UnitID, &sub.UnitName, &roomTypesJSON, &mode,
+			&sub.Filters.MaxRent, &sub.Filters.MinFloorArea, &sub.Filters.MinFloor, &sub.Filters.MaxFloor, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		if len(roomTypesJSON) > 0 {
+			if err := json.Unmarshal(roomTypesJSON, &sub.RoomTypes); err != nil {
+				log.Printf("Skipping subscription %d with invalid room types: %v", sub.ID, err)
+				continue
+			}
+		}
+		sub.Mode = models.SubscriptionMode(mode.String)
+		sub.CreatedAt = createdAt.Time
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+var (
+	_ UserStore         = (*PostgresUserStore)(nil)
+	_ UnitStore         = (*PostgresUnitStore)(nil)
+	_ SubscriptionStore = (*PostgresSubscriptionStore)(nil)
+)
+
+// likeEscaper escapes the LIKE wildcards, with \ as the escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPattern returns an ILIKE pattern, used with ESCAPE '\', matching
+// values that contain query literally
+func containsPattern(query string) string {
+	return "%" + likeEscaper.Replace(query) + "%"
+}
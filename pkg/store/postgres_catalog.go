@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// PostgresCatalogStore is a CatalogStore on the prefectures, areas and skcs
+// tables
+type PostgresCatalogStore struct {
+	DB *sql.DB
+}
+
+func (s *PostgresCatalogStore) Prefectures(ctx context.Context, urCodes []string) ([]models.Prefecture, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.id, p.name, p.ur_code, p.code, r.code
+		FROM prefectures p
+		JOIN regions r ON r.id = p.region_id
+		WHERE cardinality($1::text[]) = 0 OR p.ur_code = ANY($1)
+		ORDER BY p.ur_code`, pq.Array(urCodes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prefectures: %w", err)
+	}
+	defer rows.Close()
+
+	var prefs []models.Prefecture
+	for rows.Next() {
+		var pref models.Prefecture
+		if err := rows.Scan(&pref.ID, &pref.Name, &pref.URCode, &pref.Code, &pref.Region); err != nil {
+			return nil, fmt.Errorf("failed to scan prefecture: %w", err)
+		}
+		prefs = append(prefs, pref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read prefectures: %w", err)
+	}
+	return prefs, nil
+}
+
+func (s *PostgresCatalogStore) SaveArea(ctx context.Context, prefectureID int, area models.Area) (int, error) {
+	var id int
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO areas (prefecture_id, name, ur_area_code)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (prefecture_id, ur_area_code) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id`, prefectureID, area.Name, area.Code).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save area: %w", err)
+	}
+	return id, nil
+}
+
+func (s *PostgresCatalogStore) SaveSKC(ctx context.Context, areaID int, skc models.SKC) (int, error) {
+	var id int
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO skcs (area_id, name, code, ur_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (area_id, ur_id) DO UPDATE SET name = EXCLUDED.name, code = EXCLUDED.code
+		RETURNING id`, areaID, skc.Name, skc.Code, skc.URID).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save skc: %w", err)
+	}
+	return id, nil
+}
+
+var _ CatalogStore = (*PostgresCatalogStore)(nil)
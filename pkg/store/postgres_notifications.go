@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/poprih/ur-monitor/pkg/notify"
+)
+
+// PostgresNotificationStore is a notify.Queue on the notifications table.
+// Claims are committed before the dispatcher calls LINE, so no row lock is
+// held during requests.
+type PostgresNotificationStore struct {
+	DB *sql.DB
+}
+
+func (s *PostgresNotificationStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]notify.Notification, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE notifications
+		SET status = 'sending', attempts = attempts + 1, claimed_until = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM notifications
+			WHERE (status = 'pending' AND next_attempt_at <= NOW())
+				OR (status = 'sending' AND claimed_until < NOW())
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, line_user_id, unit_id, subscription_id, messages, attempts, claimed_until`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []notify.Notification
+	for rows.Next() {
+		n := notify.Notification{Status: notify.StatusSending}
+		if err := rows.Scan(&n.ID, &n.UserID, &n.UnitID, &n.SubscriptionID, &n.Messages, &n.Attempts, &n.ClaimedUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		batch = append(batch, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notifications: %w", err)
+	}
+
+	sort.Slice(batch, func(i, j int) bool { return batch[i].ID < batch[j].ID })
+	return batch, nil
+}
+
+func (s *PostgresNotificationStore) Record(ctx context.Context, outcomes []notify.Outcome) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, outcome := range outcomes {
+		if err := recordOutcome(ctx, tx, outcome); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit notifications: %w", err)
+	}
+	return nil
+}
+
+// recordOutcome stores the outcome of one notification's delivery attempt
+func recordOutcome(ctx context.Context, tx *sql.Tx, outcome notify.Outcome) error {
+	switch outcome.Status {
+	case notify.StatusSent:
+		_, err := tx.ExecContext(ctx, `
+			UPDATE notifications
+			SET status = 'sent', sent_at = NOW(), claimed_until = NULL, last_error = NULL
+			WHERE id = $1 AND status = 'sending'`, outcome.ID)
+		if err != nil {
+			return fmt.Errorf("failed to mark notification %d as sent: %w", outcome.ID, err)
+		}
+		if !outcome.SubscriptionID.Valid {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE subscriptions
+			SET deleted_at = NOW()
+			WHERE id = $1 AND mode = 'oneshot' AND deleted_at IS NULL`, outcome.SubscriptionID.Int64)
+		if err != nil {
+			return fmt.Errorf("failed to end one-shot subscription %d: %w", outcome.SubscriptionID.Int64, err)
+		}
+
+	case notify.StatusFailed:
+		_, err := tx.ExecContext(ctx, `
+			UPDATE notifications
+			SET status = 'failed', claimed_until = NULL, last_error = $2
+			WHERE id = $1 AND status = 'sending'`, outcome.ID, outcome.Error)
+		if err != nil {
+			return fmt.Errorf("failed to mark notification %d as failed: %w", outcome.ID, err)
+		}
+
+	case notify.StatusPending:
+		_, err := tx.ExecContext(ctx, `
+			UPDATE notifications
+			SET status = 'pending', claimed_until = NULL, last_error = $2, next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond'
+			WHERE id = $1 AND status = 'sending'`, outcome.ID, outcome.Error, outcome.RetryAfter.Milliseconds())
+		if err != nil {
+			return fmt.Errorf("failed to reschedule notification %d: %w", outcome.ID, err)
+		}
+
+	default:
+		return fmt.Errorf("notification %d has no outcome status %q", outcome.ID, outcome.Status)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// PostgresPlanStore is a PlanStore on the plans, user_plans and
+// plan_changes tables
+type PostgresPlanStore struct {
+	DB *sql.DB
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const planColumns = `p.id, p.code, p.name, p.max_subscriptions, p.max_room_types,
+	p.check_interval_minutes, p.allow_persistent, p.is_default`
+
+func scanPlan(row interface{ Scan(...any) error }, extra ...any) (models.Plan, error) {
+	var plan models.Plan
+	var maxSubscriptions, maxRoomTypes sql.NullInt64
+	var intervalMinutes int
+	dest := append([]any{&plan.ID, &plan.Code, &plan.Name, &maxSubscriptions, &maxRoomTypes,
+		&intervalMinutes, &plan.AllowPersistent, &plan.IsDefault}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return plan, err
+	}
+
+	if maxSubscriptions.Valid {
+		n := int(maxSubscriptions.Int64)
+		plan.MaxSubscriptions = &n
+	}
+	if maxRoomTypes.Valid {
+		n := int(maxRoomTypes.Int64)
+		plan.MaxRoomTypes = &n
+	}
+	plan.CheckInterval = time.Duration(intervalMinutes) * time.Minute
+	return plan, nil
+}
+
+func (s *PostgresPlanStore) List(ctx context.Context) ([]models.Plan, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+planColumns+" FROM plans p ORDER BY p.id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plans: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []models.Plan
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan plan: %w", err)
+		}
+		plans = append(plans, plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read plans: %w", err)
+	}
+	return plans, nil
+}
+
+func (s *PostgresPlanStore) Get(ctx context.Context, code string) (*models.Plan, error) {
+	plan, err := scanPlan(s.DB.QueryRowContext(ctx, "SELECT "+planColumns+" FROM plans p WHERE p.code = $1", code))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query plan: %w", err)
+	}
+	return &plan, nil
+}
+
+func (s *PostgresPlanStore) Active(ctx context.Context, lineUserID string) (*models.UserPlan, error) {
+	return activePlan(ctx, s.DB, lineUserID)
+}
+
+func (s *PostgresPlanStore) Assign(ctx context.Context, lineUserID, planCode string, expiresAt *time.Time, changedBy, reason string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockUser(ctx, tx, lineUserID, false); err != nil {
+		return err
+	}
+
+	var planID int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM plans WHERE code = $1", planCode).Scan(&planID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to query plan: %w", err)
+	}
+
+	var oldPlanID sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT plan_id FROM user_plans WHERE line_user_id = $1", lineUserID).Scan(&oldPlanID)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to query current plan: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_plans (line_user_id, plan_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (line_user_id) DO UPDATE SET plan_id = $2, expires_at = $3`,
+		lineUserID, planID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to assign plan: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO plan_changes (line_user_id, old_plan_id, new_plan_id, expires_at, changed_by, reason)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))`,
+		lineUserID, oldPlanID, planID, expiresAt, changedBy, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record plan change: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit plan change: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresPlanStore) History(ctx context.Context, lineUserID string) ([]models.PlanChange, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT c.id, c.line_user_id, COALESCE(op.code, ''), np.code, c.expires_at,
+			c.changed_by, COALESCE(c.reason, ''), c.created_at
+		FROM plan_changes c
+		LEFT JOIN plans op ON op.id = c.old_plan_id
+		JOIN plans np ON np.id = c.new_plan_id
+		WHERE c.line_user_id = $1
+		ORDER BY c.id
+	`, lineUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plan changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []models.PlanChange
+	for rows.Next() {
+		var change models.PlanChange
+		var expiresAt, createdAt sql.NullTime
+		if err := rows.Scan(&change.ID, &change.LineUserID, &change.OldPlanCode, &change.NewPlanCode, &expiresAt,
+			&change.ChangedBy, &change.Reason, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan plan change: %w", err)
+		}
+		if expiresAt.Valid {
+			change.ExpiresAt = &expiresAt.Time
+		}
+		change.CreatedAt = createdAt.Time
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read plan changes: %w", err)
+	}
+	return changes, nil
+}
+
+// activePlan returns the user's unexpired assignment, or the default plan
+func activePlan(ctx context.Context, q queryer, lineUserID string) (*models.UserPlan, error) {
+	userPlan := models.UserPlan{LineUserID: lineUserID}
+	var expiresAt sql.NullTime
+	plan, err := scanPlan(q.QueryRowContext(ctx, `
+		SELECT `+planColumns+`, up.expires_at
+		FROM plans p
+		LEFT JOIN user_plans up ON up.plan_id = p.id AND up.line_user_id = $1
+		WHERE (up.line_user_id IS NOT NULL AND (up.expires_at IS NULL OR up.expires_at > NOW()))
+			OR p.is_default
+		ORDER BY up.line_user_id IS NULL
+		LIMIT 1`, lineUserID), &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user %s has no plan and there is no default plan", lineUserID)
+		}
+		return nil, fmt.Errorf("failed to query active plan: %w", err)
+	}
+
+	userPlan.Plan = plan
+	if expiresAt.Valid {
+		userPlan.ExpiresAt = &expiresAt.Time
+	}
+	return &userPlan, nil
+}
+
+// lockUser takes a row lock on the user for the rest of tx, creating the
+// user first if create is set. Without create, a missing user is
+// ErrNotFound.
+func lockUser(ctx context.Context, tx *sql.Tx, lineUserID string, create bool) error {
+	if create {
+		_, err := tx.ExecContext(ctx, "INSERT INTO users (line_user_id) VALUES ($1) ON CONFLICT (line_user_id) DO NOTHING", lineUserID)
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+	}
+
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE line_user_id = $1 FOR UPDATE", lineUserID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+	return nil
+}
+
+var _ PlanStore = (*PostgresPlanStore)(nil)
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/poprih/ur-monitor/pkg/checkcursor"
+	"github.com/poprih/ur-monitor/pkg/notify"
+	"github.com/poprih/ur-monitor/pkg/snapshot"
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+// PostgresRoomStore is a RoomStore on the room_snapshots table that queues
+// alerts in the notifications table
+type PostgresRoomStore struct {
+	DB *sql.DB
+}
+
+func (s *PostgresRoomStore) Record(ctx context.Context, unitID int, rooms []urclient.Room, alerts func(snapshot.Changes) []notify.Alert) (snapshot.Changes, error) {
+	return snapshot.Update(ctx, s.DB, unitID, rooms, func(tx *sql.Tx, changes snapshot.Changes) error {
+		return notify.EnqueueAlerts(ctx, tx, unitID, alerts(changes))
+	})
+}
+
+// PostgresCursorStore is a CursorStore on the room_check_cursors table that
+// reserves shards with advisory locks
+type PostgresCursorStore struct {
+	DB *sql.DB
+}
+
+func (s *PostgresCursorStore) Lock(ctx context.Context, shard checkcursor.Shard) (func(), error) {
+	return checkcursor.Lock(ctx, s.DB, shard)
+}
+
+func (s *PostgresCursorStore) Load(ctx context.Context, shard checkcursor.Shard) (int, error) {
+	return checkcursor.Load(ctx, s.DB, shard)
+}
+
+func (s *PostgresCursorStore) Advance(ctx context.Context, shard checkcursor.Shard, lastUnitID int) error {
+	return checkcursor.Advance(ctx, s.DB, shard, lastUnitID)
+}
+
+func (s *PostgresCursorStore) Complete(ctx context.Context, shard checkcursor.Shard) error {
+	return checkcursor.Complete(ctx, s.DB, shard)
+}
+
+var (
+	_ RoomStore   = (*PostgresRoomStore)(nil)
+	_ CursorStore = (*PostgresCursorStore)(nil)
+)
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/poprih/ur-monitor/db"
+	"github.com/poprih/ur-monitor/db/migrate"
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// openTestDB opens DATABASE_URL and migrates it to the latest version, or
+// skips the test if it is unset. Tests create their own rows with unique
+// names and delete them afterwards, but the database should still be a
+// disposable one.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	database, err := db.Open(ctx, db.ConfigFromEnv())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	migrator, err := migrate.New(database)
+	if err != nil {
+		t.Fatalf("migrate.New() error = %v", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	return database
+}
+
+// addTestUnits inserts n units with names and codes unique to this run and
+// returns their ids
+func addTestUnits(t *testing.T, database *sql.DB, n int) []int {
+	t.Helper()
+	ctx := context.Background()
+	run := time.Now().UnixNano() % 1_000_000_000
+
+	ids := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		var id int
+		err := database.QueryRowContext(ctx, "INSERT INTO units (unit_name, unit_code) VALUES ($1, $2) RETURNING id",
+			fmt.Sprintf("テスト団地 %d-%d", run, i), models.UnitCode{Shisya: "99", Danchi: fmt.Sprintf("%03d", i), Shikibetu: fmt.Sprint(run)}).Scan(&id)
+		if err != nil {
+			t.Fatalf("failed to insert unit: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	t.Cleanup(func() {
+		database.ExecContext(context.Background(), "DELETE FROM units WHERE id = ANY($1)", pq.Array(ids))
+	})
+	return ids
+}
+
+func TestPostgresSubscribeHoldsPlanLimitUnderConcurrency(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+	stores := NewPostgres(database)
+
+	free, err := stores.Plans.Get(ctx, "free")
+	if err != nil {
+		t.Fatalf("Plans.Get(free) error = %v", err)
+	}
+	if free.MaxSubscriptions == nil {
+		t.Fatal("free plan has no subscription limit")
+	}
+	limit := *free.MaxSubscriptions
+
+	const attempts = 10
+	unitIDs := addTestUnits(t, database, attempts)
+	userID := fmt.Sprintf("Utest-subscribe-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		database.ExecContext(context.Background(), "DELETE FROM users WHERE line_user_id = $1", userID)
+	})
+
+	// Every goroutine subscribes the same new user to a different unit at
+	// once, so each would pass the check if it did not see the others
+	start := make(chan struct{})
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i, unitID := range unitIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = stores.Subscriptions.Subscribe(ctx, models.Subscription{LineUserID: userID, UnitID: unitID})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, models.ErrSubscriptionLimit):
+			t.Errorf("Subscribe() error = %v, want nil or ErrSubscriptionLimit", err)
+		}
+	}
+	if succeeded != limit {
+		t.Errorf("%d of %d concurrent subscribes succeeded, want the free plan's %d", succeeded, attempts, limit)
+	}
+
+	count, err := stores.Subscriptions.CountActive(ctx, userID)
+	if err != nil {
+		t.Fatalf("CountActive() error = %v", err)
+	}
+	if count != limit {
+		t.Errorf("active subscriptions = %d, want %d", count, limit)
+	}
+}
+
+func TestPostgresSubscribeReplacesSameUnitAtLimit(t *testing.T) {
+	database := openTestDB(t)
+	stores := NewPostgres(database)
+
+	free, err := stores.Plans.Get(context.Background(), "free")
+	if err != nil {
+		t.Fatalf("Plans.Get(free) error = %v", err)
+	}
+	unitIDs := addTestUnits(t, database, *free.MaxSubscriptions+1)
+	userID := fmt.Sprintf("Utest-resubscribe-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		database.ExecContext(context.Background(), "DELETE FROM users WHERE line_user_id = $1", userID)
+	})
+
+	testResubscribeAtLimit(t, stores, userID, unitIDs)
+}
+
+func TestPostgresMatch(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+
+	// Unit names are unique, so each gets this run's number
+	suffix := fmt.Sprintf(" %d", time.Now().UnixNano()%1_000_000_000)
+	var ids []int
+	t.Cleanup(func() {
+		database.ExecContext(context.Background(), "DELETE FROM units WHERE id = ANY($1)", pq.Array(ids))
+	})
+	for _, name := range matchNames {
+		var id int
+		err := database.QueryRowContext(ctx, "INSERT INTO units (unit_name) VALUES ($1) RETURNING id", name+suffix).Scan(&id)
+		if err != nil {
+			t.Fatalf("failed to insert unit: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	testMatch(t, NewPostgres(database).Units, suffix)
+}
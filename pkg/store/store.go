@@ -0,0 +1,169 @@
+// Package store provides typed access to users, units and subscriptions,
+// backed by Postgres in production and by memory in tests.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/poprih/ur-monitor/lib/models"
+	"github.com/poprih/ur-monitor/pkg/checkcursor"
+	"github.com/poprih/ur-monitor/pkg/notify"
+	"github.com/poprih/ur-monitor/pkg/snapshot"
+	"github.com/poprih/ur-monitor/pkg/urclient"
+)
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("store: not found")
+
+// UserStore manages LINE users
+type UserStore interface {
+	// Get returns the user, or ErrNotFound
+	Get(ctx context.Context, lineUserID string) (*models.User, error)
+	// Search returns a page of users whose LINE user id contains query,
+	// newest first. An empty query matches everyone.
+	Search(ctx context.Context, query string, limit, offset int) ([]models.User, error)
+	// Ensure returns the user, creating a non-premium one if needed
+	Ensure(ctx context.Context, lineUserID string) (*models.User, error)
+	// Follow creates the user or refreshes their reply token
+	Follow(ctx context.Context, lineUserID, replyToken string) error
+	// SetReplyToken updates the reply token of an existing user
+	SetReplyToken(ctx context.Context, lineUserID, replyToken string) error
+	// Delete removes the user
+	Delete(ctx context.Context, lineUserID string) error
+}
+
+// UnitStore manages UR properties
+type UnitStore interface {
+	// List returns every unit still listed by UR, ordered by id
+	List(ctx context.Context) ([]models.Unit, error)
+	// Get returns the unit, or ErrNotFound
+	Get(ctx context.Context, id int) (*models.Unit, error)
+	// Match returns the listed units whose name is the same as name after
+	// unitsearch.Normalize, or if there are none, up to limit units with
+	// similar names, most similar first
+	Match(ctx context.Context, name string, limit int) ([]models.Unit, error)
+	// Search returns a page of units whose name or code contains query,
+	// with their subscriber counts, ordered by id. An empty query matches
+	// every unit.
+	Search(ctx context.Context, query string, limit, offset int) ([]models.UnitSummary, error)
+	// ListSubscribed returns the units with active subscriptions whose id is
+	// greater than after and congruent to shardIndex modulo shardCount,
+	// ordered by id
+	ListSubscribed(ctx context.Context, shardIndex, shardCount, after int) ([]models.Unit, error)
+	// LastCheck returns the latest room check of the unit, or nil if it has
+	// never been checked
+	LastCheck(ctx context.Context, unitID int) (*models.UnitCheck, error)
+	// Upsert saves the unit by its code, listing it under unit.SKCID again
+	// if it was delisted, and reports whether it was inserted and whether an
+	// existing unit changed. unit.ID and unit.Delisted are ignored.
+	// Concurrent upserts of one code do not conflict.
+	Upsert(ctx context.Context, unit models.Unit) (inserted, changed bool, err error)
+	// Delist marks the listed units of the skc whose code is not in codes as
+	// delisted and returns them
+	Delist(ctx context.Context, skcID int, codes []models.UnitCode) ([]models.Unit, error)
+}
+
+// CatalogStore manages the prefectures, areas and skcs units are listed
+// under
+type CatalogStore interface {
+	// Prefectures returns the prefectures with the given UR codes, or every
+	// prefecture if urCodes is empty, ordered by UR code. Unknown codes are
+	// left out.
+	Prefectures(ctx context.Context, urCodes []string) ([]models.Prefecture, error)
+	// SaveArea creates or renames the prefecture's area with area.Code and
+	// returns its id
+	SaveArea(ctx context.Context, prefectureID int, area models.Area) (int, error)
+	// SaveSKC creates or updates the area's skc with skc.URID and returns its
+	// id
+	SaveSKC(ctx context.Context, areaID int, skc models.SKC) (int, error)
+}
+
+// SubscriptionStore manages subscriptions. Cancelled subscriptions are kept
+// as soft-deleted rows and are never returned.
+type SubscriptionStore interface {
+	// CountActive returns how many active subscriptions the user has
+	CountActive(ctx context.Context, lineUserID string) (int, error)
+	// Save creates the subscription, or replaces the conditions of the user's
+	// subscription to the same unit and reactivates it
+	Save(ctx context.Context, sub models.Subscription) error
+	// Subscribe saves the subscription like Save, creating the user if
+	// needed, but fails with one of the models.Plan errors if the user's
+	// active plan does not permit it. An active subscription to the same
+	// unit is replaced, so it does not count towards the plan's subscription
+	// limit. The check and the write are atomic, so concurrent calls for one
+	// user cannot exceed the plan's limits.
+	Subscribe(ctx context.Context, sub models.Subscription) error
+	// Cancel ends the user's subscription to a unit and reports whether
+	// there was one
+	Cancel(ctx context.Context, lineUserID string, unitID int) (bool, error)
+	// CancelAll ends every subscription of the user and returns how many
+	// there were
+	CancelAll(ctx context.Context, lineUserID string) (int, error)
+	// DeleteAll removes every subscription of the user, including
+	// cancelled ones
+	DeleteAll(ctx context.Context, lineUserID string) error
+	// ListByUser returns the user's active subscriptions with unit names,
+	// oldest first
+	ListByUser(ctx context.Context, lineUserID string) ([]models.Subscription, error)
+	// ListByUnit returns the active subscriptions to a unit
+	ListByUnit(ctx context.Context, unitID int) ([]models.Subscription, error)
+}
+
+// PlanStore manages plans and the plan assignments of users
+type PlanStore interface {
+	// List returns every plan
+	List(ctx context.Context) ([]models.Plan, error)
+	// Get returns the plan with the given code, or ErrNotFound
+	Get(ctx context.Context, code string) (*models.Plan, error)
+	// Active returns the plan the user is on: their assignment if it has not
+	// expired, otherwise the default plan
+	Active(ctx context.Context, lineUserID string) (*models.UserPlan, error)
+	// Assign puts an existing user on a plan until expiresAt, or for good if
+	// it is nil, and records the change in the audit log
+	Assign(ctx context.Context, lineUserID, planCode string, expiresAt *time.Time, changedBy, reason string) error
+	// History returns the user's plan changes, oldest first
+	History(ctx context.Context, lineUserID string) ([]models.PlanChange, error)
+}
+
+// RoomStore keeps the rooms last seen in each unit and queues the alerts
+// they trigger
+type RoomStore interface {
+	// Record saves the rooms seen by a check of the unit together with the
+	// alerts returned by alerts for the changes since the previous check,
+	// and returns the changes. Either everything is saved or nothing is, so
+	// after a failure the next check reports the same changes again.
+	// Concurrent checks of one unit are serialized. alerts must not use the
+	// stores.
+	Record(ctx context.Context, unitID int, rooms []urclient.Room, alerts func(snapshot.Changes) []notify.Alert) (snapshot.Changes, error)
+}
+
+// CursorStore tracks how far each shard of the room check has got
+type CursorStore interface {
+	// Lock reserves the shard for one run, or fails with
+	// checkcursor.ErrLocked if another run holds it. release ends the
+	// reservation.
+	Lock(ctx context.Context, shard checkcursor.Shard) (release func(), err error)
+	// Load returns the id of the last unit the shard finished, or 0 if the
+	// shard is at the start of a cycle
+	Load(ctx context.Context, shard checkcursor.Shard) (int, error)
+	// Advance records that the shard has finished every unit up to and
+	// including lastUnitID
+	Advance(ctx context.Context, shard checkcursor.Shard, lastUnitID int) error
+	// Complete records that the shard has finished every unit, so the next
+	// run starts a new cycle
+	Complete(ctx context.Context, shard checkcursor.Shard) error
+}
+
+// Stores groups the stores a handler depends on
+type Stores struct {
+	Users         UserStore
+	Units         UnitStore
+	Subscriptions SubscriptionStore
+	Plans         PlanStore
+	Rooms         RoomStore
+	Cursors       CursorStore
+	Catalog       CatalogStore
+	Notifications notify.Queue
+}
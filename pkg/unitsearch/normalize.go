@@ -0,0 +1,50 @@
+package unitsearch
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// smallKana maps the small katakana used as counters in place names, as in
+// 霞ヶ関 or 一ヵ町, to the full-size ones they are often typed as
+var smallKana = map[rune]rune{
+	'ヶ': 'ケ', 'ヵ': 'カ',
+}
+
+// ignoredRunes are dropped entirely; users rarely type them the same way
+// the UR catalog does. NFKC has already folded their full-width forms.
+var ignoredRunes = map[rune]bool{
+	'・': true, '-': true, '‐': true, '〜': true, '~': true, '(': true, ')': true,
+	'「': true, '」': true, '.': true, '。': true, '、': true, ',': true,
+}
+
+// Normalize folds the differences users commonly make when typing a property
+// name. NFKC turns full-width ASCII into half-width, half-width katakana and
+// sound marks into full-width kana, and circled digits, Roman numerals and
+// other compatibility forms into plain ones. On top of that hiragana becomes
+// katakana, ヶ and ヵ become ケ and カ, letters are lowercased and whitespace
+// and punctuation are removed.
+func Normalize(s string) string {
+	s = norm.NFKC.String(s)
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		// Hiragana to katakana
+		if r >= 'ぁ' && r <= 'ゖ' {
+			r += 'ァ' - 'ぁ'
+		}
+		if full, ok := smallKana[r]; ok {
+			r = full
+		}
+
+		if unicode.IsSpace(r) || ignoredRunes[r] || unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package unitsearch
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"catalog name", "東雲キャナルコートCODAN", "東雲キャナルコートcodan"},
+		{"full-width latin", "東雲キャナルコートＣＯＤＡＮ", "東雲キャナルコートcodan"},
+		{"half-width katakana", "ｴﾋﾞｽﾋﾞｭｰﾀﾜｰ", "エビスビュータワー"},
+		{"half-width semi-voiced mark", "ｼﾃｨｺｰﾄ ﾊﾟｰｸ", "シティコートパーク"},
+		{"hiragana", "えびすびゅーたわー", "エビスビュータワー"},
+		{"spaces", "光が丘 パークタウン　大通り中央", "光ガ丘パークタウン大通リ中央"},
+		{"small ke", "桜ヶ丘", "桜ケ丘"},
+		{"small ke as full-size", "桜ケ丘", "桜ケ丘"},
+		{"half-width ke", "桜ｹ丘", "桜ケ丘"},
+		{"small ka", "一ヵ町", "一カ町"},
+		{"circled digit", "大島四丁目①号棟", "大島四丁目1号棟"},
+		{"roman numeral", "コンフォール南日吉Ⅱ", "コンフォール南日吉ii"},
+		{"full-width digits", "多摩ニュータウン永山４丁目", "多摩ニュータウン永山4丁目"},
+		{"punctuation", "ヌーヴェル赤羽台（Ａ・Ｂ棟）", "ヌーヴェル赤羽台ab棟"},
+		{"stray sound mark", "ｱﾞ", "ア"},
+		{"empty", "　 ・", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.in); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
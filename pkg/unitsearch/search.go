@@ -0,0 +1,133 @@
+package unitsearch
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// MinScore is the lowest score a candidate needs to be returned by Rank
+	MinScore = 0.5
+	// AutoSelectScore is the lowest score at which a lone candidate is picked
+	// without asking the user
+	AutoSelectScore = 0.8
+)
+
+// Candidate is a searchable unit
+type Candidate struct {
+	ID   int
+	Name string
+}
+
+// Match is a candidate with its similarity to the query
+type Match struct {
+	Candidate
+	Score float64
+	Exact bool
+}
+
+// Rank scores every candidate against query and returns the ones scoring at
+// least MinScore, best first. Exact matches after normalization score 1,
+// prefix and substring matches score above 0.7 scaled by how much of the
+// name the query covers, and everything else is scored by edit distance.
+func Rank(query string, candidates []Candidate) []Match {
+	q := Normalize(query)
+	if q == "" {
+		return nil
+	}
+
+	var matches []Match
+	for _, c := range candidates {
+		name := Normalize(c.Name)
+		if name == "" {
+			continue
+		}
+
+		score := similarity(q, name)
+		if score < MinScore {
+			continue
+		}
+		matches = append(matches, Match{Candidate: c, Score: score, Exact: q == name})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Name < matches[j].Name
+	})
+
+	return matches
+}
+
+// Resolve picks the unit the query refers to. It returns the match when the
+// query is unambiguous: an exact match, or a single candidate scoring at
+// least AutoSelectScore. Otherwise it returns up to limit ranked candidates for the
+// user to choose from, which is empty when nothing matched.
+func Resolve(query string, candidates []Candidate, limit int) (*Match, []Match) {
+	matches := Rank(query, candidates)
+
+	var exact []Match
+	for _, m := range matches {
+		if m.Exact {
+			exact = append(exact, m)
+		}
+	}
+	switch {
+	case len(exact) == 1:
+		return &exact[0], nil
+	case len(exact) == 0 && len(matches) == 1 && matches[0].Score >= AutoSelectScore:
+		return &matches[0], nil
+	}
+
+	if len(exact) > 1 {
+		matches = exact
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return nil, matches
+}
+
+// similarity scores two normalized strings between 0 and 1
+func similarity(q, name string) float64 {
+	if q == name {
+		return 1
+	}
+
+	qLen, nameLen := len([]rune(q)), len([]rune(name))
+	coverage := float64(qLen) / float64(max(qLen, nameLen))
+
+	switch {
+	case strings.HasPrefix(name, q):
+		return 0.8 + 0.15*coverage
+	case strings.Contains(name, q):
+		return 0.7 + 0.15*coverage
+	}
+
+	distance := levenshtein([]rune(q), []rune(name))
+	return 1 - float64(distance)/float64(max(qLen, nameLen))
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,95 @@
+package unitsearch
+
+import (
+	"fmt"
+	"testing"
+)
+
+var danchi = []Candidate{
+	{1, "東雲キャナルコートCODAN"},
+	{2, "東雲キャナルコート"},
+	{3, "光が丘パークタウン大通り中央"},
+	{4, "光が丘パークタウンいちょう通り八番街"},
+	{5, "大島四丁目"},
+	{6, "大島六丁目"},
+	{7, "ひばりが丘パークヒルズ"},
+	{8, "桜ヶ丘"},
+}
+
+// ids returns the ids of matches in order
+func ids(matches []Match) string {
+	var ids []int
+	for _, m := range matches {
+		ids = append(ids, m.ID)
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"exact before prefix", "東雲キャナルコート", "[2 1]"},
+		{"full-width and spaced", "東雲 キャナルコート ＣＯＤＡＮ", "[1 2]"},
+		{"half-width katakana with kanji", "ひばりが丘ﾊﾟｰｸﾋﾙｽﾞ", "[7]"},
+		{"kana for kanji by edit distance", "ﾋﾊﾞﾘｶﾞｵｶﾊﾟｰｸﾋﾙｽﾞ", "[7]"},
+		{"prefix, more coverage first", "光が丘パークタウン", "[3 4]"},
+		{"typo by edit distance", "大島四町目", "[5 6]"},
+		// Equal scores fall back to name order
+		{"tie", "大島", "[6 5]"},
+		{"small ke typed full-size", "桜ケ丘", "[8]"},
+		{"no match", "高島平", "[]"},
+		{"empty", "　", "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(Rank(tt.query, danchi)); got != tt.want {
+				t.Errorf("Rank(%q) = %s, want %s", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankScoresExactMatches(t *testing.T) {
+	matches := Rank("ﾋﾊﾞﾘｶﾞ丘ﾊﾟｰｸﾋﾙｽﾞ", danchi)
+	if len(matches) != 1 || !matches[0].Exact || matches[0].Score != 1 {
+		t.Errorf("Rank() = %v, want one exact match scoring 1", matches)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		wantMatch       int
+		wantSuggestions string
+	}{
+		{"exact", "東雲キャナルコート", 2, "[]"},
+		{"exact in half-width katakana", "ﾋﾊﾞﾘｶﾞ丘 ﾊﾟｰｸﾋﾙｽﾞ", 7, "[]"},
+		{"single close match", "ひばりが丘パークヒル", 7, "[]"},
+		{"ambiguous prefix", "光が丘パークタウン", 0, "[3 4]"},
+		{"typo", "大島四町目", 0, "[5 6]"},
+		{"nothing", "高島平", 0, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, suggestions := Resolve(tt.query, danchi, 3)
+			gotMatch := 0
+			if match != nil {
+				gotMatch = match.ID
+			}
+			if gotMatch != tt.wantMatch || ids(suggestions) != tt.wantSuggestions {
+				t.Errorf("Resolve(%q) = %d, %s, want %d, %s", tt.query, gotMatch, ids(suggestions), tt.wantMatch, tt.wantSuggestions)
+			}
+		})
+	}
+}
+
+func TestResolveLimitsSuggestions(t *testing.T) {
+	_, suggestions := Resolve("光が丘パークタウン", danchi, 1)
+	if ids(suggestions) != "[3]" {
+		t.Errorf("Resolve() suggestions = %s, want the best one only", ids(suggestions))
+	}
+}
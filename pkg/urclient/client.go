@@ -0,0 +1,95 @@
+package urclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// DefaultTimeout is the request timeout used when none is given to NewHTTPClient
+const DefaultTimeout = 15 * time.Second
+
+// Response represents the response from UR API
+type Response struct {
+	Count int    `json:"count"`
+	Room  []Room `json:"room"`
+}
+
+// RoomAvailabilityFetcher fetches the vacant rooms of a single UR unit
+type RoomAvailabilityFetcher interface {
+	FetchRooms(ctx context.Context, code models.UnitCode) (*Response, error)
+}
+
+// HTTPClient fetches room availability from the UR API over HTTP
+type HTTPClient struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewHTTPClient creates a client posting to baseURL+path. A zero timeout
+// falls back to DefaultTimeout.
+func NewHTTPClient(baseURL, path string, timeout time.Duration) (*HTTPClient, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("UR API base URL is not set")
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &HTTPClient{
+		endpoint:   baseURL + path,
+		httpClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// FetchRooms fetches available room data from the UR API
+func (c *HTTPClient) FetchRooms(ctx context.Context, code models.UnitCode) (*Response, error) {
+	if err := code.Validate(); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("shisya", code.Shisya)
+	form.Set("danchi", code.Danchi)
+	form.Set("shikibetu", code.Shikibetu)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "application/json, text/javascript, */*; q=0.01")
+	req.Header.Set("Origin", "https://www.ur-net.go.jp")
+	req.Header.Set("Referer", "https://www.ur-net.go.jp/")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var data Response
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, &DecodeError{ContentType: resp.Header.Get("Content-Type"), Body: string(body), Err: err}
+	}
+
+	return &data, nil
+}
@@ -0,0 +1,39 @@
+package urclient
+
+import "fmt"
+
+// maxErrorBody caps how much of a response body is kept on an error
+const maxErrorBody = 512
+
+// StatusError is returned when the UR API responds with a non-200 status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("UR API error: %s (status code: %d)", truncate(e.Body), e.StatusCode)
+}
+
+// DecodeError is returned when the UR API responds with something that is
+// not the expected JSON document, e.g. a maintenance HTML page
+type DecodeError struct {
+	ContentType string
+	Body        string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode JSON response (content type: %q): %v", e.ContentType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func truncate(s string) string {
+	if len(s) <= maxErrorBody {
+		return s
+	}
+	return s[:maxErrorBody] + "..."
+}
@@ -0,0 +1,66 @@
+package urclient
+
+import (
+	"context"
+	"sync"
+
+	"github.com/poprih/ur-monitor/lib/models"
+)
+
+// FakeFetcher is an in-memory RoomAvailabilityFetcher for tests and local runs.
+// Responses and errors are keyed by unit code.
+type FakeFetcher struct {
+	mu        sync.Mutex
+	responses map[models.UnitCode]*Response
+	errors    map[models.UnitCode]error
+	calls     []models.UnitCode
+}
+
+// NewFakeFetcher creates an empty FakeFetcher. Unknown units report no vacancies.
+func NewFakeFetcher() *FakeFetcher {
+	return &FakeFetcher{
+		responses: make(map[models.UnitCode]*Response),
+		errors:    make(map[models.UnitCode]error),
+	}
+}
+
+// SetResponse sets the response returned for code
+func (f *FakeFetcher) SetResponse(code models.UnitCode, response *Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[code] = response
+	delete(f.errors, code)
+}
+
+// SetError makes every fetch of code fail with err
+func (f *FakeFetcher) SetError(code models.UnitCode, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[code] = err
+}
+
+// Calls returns the unit codes fetched so far, in order
+func (f *FakeFetcher) Calls() []models.UnitCode {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]models.UnitCode(nil), f.calls...)
+}
+
+// FetchRooms implements RoomAvailabilityFetcher
+func (f *FakeFetcher) FetchRooms(ctx context.Context, code models.UnitCode) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, code)
+
+	if err, ok := f.errors[code]; ok {
+		return nil, err
+	}
+	if response, ok := f.responses[code]; ok {
+		return response, nil
+	}
+	return &Response{}, nil
+}
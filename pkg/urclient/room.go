@@ -0,0 +1,131 @@
+package urclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Room is a single vacant room returned by the UR API
+type Room struct {
+	ID         string  `json:"id"`
+	Type       string  `json:"type"`
+	RoomNumber string  `json:"name"`
+	Floor      int     `json:"floor"`
+	Rent       int     `json:"rent"`
+	CommonFee  int     `json:"commonfee"`
+	FloorArea  float64 `json:"floorspace"`
+	DetailURL  string  `json:"roomDetailLink"`
+}
+
+// Key identifies the room when comparing two room lists. Rooms without an ID
+// (older payloads that only carried the room type) fall back to the type.
+func (r Room) Key() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return "type:" + r.Type
+}
+
+// rawRoom mirrors the UR payload, where numeric fields are usually display
+// strings such as "85,400円", "55&#13217;" or "3階"
+type rawRoom struct {
+	ID         json.RawMessage `json:"id"`
+	Type       string          `json:"type"`
+	RoomNumber string          `json:"name"`
+	Floor      json.RawMessage `json:"floor"`
+	Rent       json.RawMessage `json:"rent"`
+	CommonFee  json.RawMessage `json:"commonfee"`
+	FloorArea  json.RawMessage `json:"floorspace"`
+	DetailURL  string          `json:"roomDetailLink"`
+}
+
+// UnmarshalJSON accepts either a full room object or a bare room type string
+func (r *Room) UnmarshalJSON(data []byte) error {
+	var roomType string
+	if err := json.Unmarshal(data, &roomType); err == nil {
+		*r = Room{Type: roomType}
+		return nil
+	}
+
+	var raw rawRoom
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	id, err := parseText(raw.ID)
+	if err != nil {
+		return fmt.Errorf("invalid room id: %w", err)
+	}
+	floor, err := parseNumber(raw.Floor)
+	if err != nil {
+		return fmt.Errorf("invalid floor: %w", err)
+	}
+	rent, err := parseNumber(raw.Rent)
+	if err != nil {
+		return fmt.Errorf("invalid rent: %w", err)
+	}
+	commonFee, err := parseNumber(raw.CommonFee)
+	if err != nil {
+		return fmt.Errorf("invalid common fee: %w", err)
+	}
+	floorArea, err := parseNumber(raw.FloorArea)
+	if err != nil {
+		return fmt.Errorf("invalid floor area: %w", err)
+	}
+
+	*r = Room{
+		ID:         id,
+		Type:       strings.TrimSpace(raw.Type),
+		RoomNumber: strings.TrimSpace(raw.RoomNumber),
+		Floor:      int(floor),
+		Rent:       int(rent),
+		CommonFee:  int(commonFee),
+		FloorArea:  floorArea,
+		DetailURL:  raw.DetailURL,
+	}
+	return nil
+}
+
+// parseText reads a JSON string or number as text
+func parseText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strings.TrimSpace(s), nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+// parseNumber reads a JSON number or a display string such as "85,400円".
+// The HTML entity for ㎡ (&#13217;) is stripped before the first number in
+// the string is parsed. Missing values parse as zero.
+func parseNumber(raw json.RawMessage) (float64, error) {
+	text, err := parseText(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	text = strings.ReplaceAll(text, "&#13217;", "")
+	text = strings.ReplaceAll(text, ",", "")
+
+	start := strings.IndexAny(text, "0123456789")
+	if start < 0 {
+		return 0, nil
+	}
+	end := start
+	for end < len(text) && (text[end] == '.' || (text[end] >= '0' && text[end] <= '9')) {
+		end++
+	}
+
+	return strconv.ParseFloat(text[start:end], 64)
+}